@@ -0,0 +1,312 @@
+// Package events defines the messages the node publishes to workers and the
+// CloudEvents-style envelope they travel in.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// SpecVersion is the CloudEvents specification the envelope follows.
+	SpecVersion = "1.0"
+
+	// ContentType marks a message body as a structured-mode envelope.
+	ContentType = "application/cloudevents+json"
+
+	// Source identifies the node as the producer of every event.
+	Source = "/video-platform/node"
+)
+
+// Event types double as routing keys, so consumers can bind to one type or
+// to patterns such as "video.*". Each has the newest schema version of its
+// data; additive changes keep the version, anything a consumer could misread
+// bumps it.
+const (
+	TypeVideoUploaded  = "video.uploaded"
+	TypeVideoUpdated   = "video.updated"
+	TypeVideoDeleted   = "video.deleted"
+	TypeVideoProcessed = "video.processed"
+	TypeVideoFailed    = "video.failed"
+	TypeJobRequested   = "job.requested"
+	TypeJobCanceled    = "job.canceled"
+
+	VideoUploadedVersion  = 1
+	VideoUpdatedVersion   = 1
+	VideoDeletedVersion   = 1
+	VideoProcessedVersion = 1
+	VideoFailedVersion    = 1
+	JobRequestedVersion   = 1
+	JobCanceledVersion    = 1
+)
+
+// legacyTypes maps the per-event queue names used before topic routing to
+// the event types that replaced them.
+var legacyTypes = map[string]string{
+	"video_uploaded": TypeVideoUploaded,
+	"video_deleted":  TypeVideoDeleted,
+}
+
+// JobRoutingKey is the routing key of a job event (job.requested,
+// job.canceled) for job, so each worker binds only the jobs it runs.
+func JobRoutingKey(eventType, job string) string {
+	return eventType + "." + job
+}
+
+// RoutingKey returns the routing key for name, translating legacy queue names
+// still held by old outbox entries.
+func RoutingKey(name string) string {
+	if t, ok := legacyTypes[name]; ok {
+		return t
+	}
+	return name
+}
+
+var (
+	ErrWrongType          = errors.New("events: unexpected event type")
+	ErrUnsupportedVersion = errors.New("events: unsupported schema version")
+	ErrUnsupportedSpec    = errors.New("events: unsupported specversion")
+)
+
+// Envelope carries one event. Attributes other than the core CloudEvents ones
+// are extensions: schemaversion versions Data, videoid names the video the
+// event is about, and traceparent carries the W3C trace context.
+//
+// Bodies published before envelopes existed are the bare data object; they
+// decode as SchemaVersion 0 with an empty Type.
+type Envelope struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	SchemaVersion   int             `json:"schemaversion"`
+	VideoID         string          `json:"videoid,omitempty"`
+	TraceParent     string          `json:"traceparent,omitempty"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// New wraps data in an envelope. The id should be unique per event and stay
+// the same across redeliveries.
+func New(id, eventType string, version int, videoID, traceParent string, data interface{}) (Envelope, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("encode %s data: %w", eventType, err)
+	}
+	return Envelope{
+		SpecVersion:     SpecVersion,
+		ID:              id,
+		Type:            eventType,
+		Source:          Source,
+		Time:            time.Now().UTC(),
+		DataContentType: "application/json",
+		SchemaVersion:   version,
+		VideoID:         videoID,
+		TraceParent:     traceParent,
+		Data:            raw,
+	}, nil
+}
+
+// Decode parses a message body, accepting both envelopes and legacy bare
+// payloads. Envelopes must be of a 1.x specversion.
+func Decode(body []byte) (Envelope, error) {
+	var probe struct {
+		SpecVersion string `json:"specversion"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return Envelope{}, fmt.Errorf("events: decode body: %w", err)
+	}
+	if probe.SpecVersion == "" {
+		return Envelope{Data: body}, nil
+	}
+	if !strings.HasPrefix(probe.SpecVersion, "1.") {
+		return Envelope{}, fmt.Errorf("%w: %q", ErrUnsupportedSpec, probe.SpecVersion)
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return Envelope{}, fmt.Errorf("events: decode envelope: %w", err)
+	}
+	return env, nil
+}
+
+// check accepts legacy bodies, and envelopes of eventType up to maxVersion.
+func (e Envelope) check(eventType string, maxVersion int) error {
+	if e.Type != "" && e.Type != eventType {
+		return fmt.Errorf("%w: got %q, want %q", ErrWrongType, e.Type, eventType)
+	}
+	if e.SchemaVersion > maxVersion {
+		return fmt.Errorf("%w: %s v%d (understand up to v%d)", ErrUnsupportedVersion, eventType, e.SchemaVersion, maxVersion)
+	}
+	return nil
+}
+
+// VideoUploaded is published once an upload is stored and its metadata
+// committed.
+type VideoUploaded struct {
+	VideoID     string `json:"video_id,omitempty"`
+	Bucket      string `json:"bucket"`
+	Object      string `json:"object"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+}
+
+// DecodeVideoUploaded decodes a video.uploaded message. Legacy bodies may
+// lack video_id; in an envelope it is also taken from the videoid attribute.
+func DecodeVideoUploaded(body []byte) (Envelope, VideoUploaded, error) {
+	var data VideoUploaded
+	env, err := Decode(body)
+	if err != nil {
+		return env, data, err
+	}
+	if err := env.check(TypeVideoUploaded, VideoUploadedVersion); err != nil {
+		return env, data, err
+	}
+	if err := json.Unmarshal(env.Data, &data); err != nil {
+		return env, data, fmt.Errorf("events: decode %s data: %w", TypeVideoUploaded, err)
+	}
+	if data.VideoID == "" {
+		data.VideoID = env.VideoID
+	}
+	return env, data, nil
+}
+
+// VideoDeleted is published when a video is moved to the trash. Workers use
+// it to drop queued or in-flight work for the video.
+type VideoDeleted struct {
+	VideoID   string    `json:"video_id"`
+	Bucket    string    `json:"bucket"`
+	Object    string    `json:"object"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// DecodeVideoDeleted decodes a video.deleted message. Legacy bodies name the
+// video in id rather than video_id.
+func DecodeVideoDeleted(body []byte) (Envelope, VideoDeleted, error) {
+	var data VideoDeleted
+	env, err := Decode(body)
+	if err != nil {
+		return env, data, err
+	}
+	if err := env.check(TypeVideoDeleted, VideoDeletedVersion); err != nil {
+		return env, data, err
+	}
+	if err := json.Unmarshal(env.Data, &data); err != nil {
+		return env, data, fmt.Errorf("events: decode %s data: %w", TypeVideoDeleted, err)
+	}
+	if data.VideoID == "" && env.Type == "" {
+		var legacy struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(env.Data, &legacy); err != nil {
+			return env, data, fmt.Errorf("events: decode %s data: %w", TypeVideoDeleted, err)
+		}
+		data.VideoID = legacy.ID
+	}
+	if data.VideoID == "" {
+		data.VideoID = env.VideoID
+	}
+	return env, data, nil
+}
+
+// VideoUpdated is published when a video's editable metadata changes.
+// Fields lists the JSON names of what changed.
+type VideoUpdated struct {
+	VideoID string   `json:"video_id"`
+	Version int      `json:"version"`
+	Fields  []string `json:"fields"`
+}
+
+func DecodeVideoUpdated(body []byte) (Envelope, VideoUpdated, error) {
+	var data VideoUpdated
+	env, err := decodeData(body, TypeVideoUpdated, VideoUpdatedVersion, &data)
+	return env, data, err
+}
+
+// VideoProcessed is published when every processing job of a video is ready.
+type VideoProcessed struct {
+	VideoID          string   `json:"video_id"`
+	Resolutions      []string `json:"resolutions"`
+	StreamingFormats []string `json:"streaming_formats,omitempty"`
+}
+
+func DecodeVideoProcessed(body []byte) (Envelope, VideoProcessed, error) {
+	var data VideoProcessed
+	env, err := decodeData(body, TypeVideoProcessed, VideoProcessedVersion, &data)
+	return env, data, err
+}
+
+// VideoFailed is published when a processing job fails, turning the video's
+// status to failed.
+type VideoFailed struct {
+	VideoID string `json:"video_id"`
+	Job     string `json:"job"`
+	Error   string `json:"error"`
+}
+
+func DecodeVideoFailed(body []byte) (Envelope, VideoFailed, error) {
+	var data VideoFailed
+	env, err := decodeData(body, TypeVideoFailed, VideoFailedVersion, &data)
+	return env, data, err
+}
+
+// JobRequested asks a worker to run one pipeline step on a video. It is
+// published once every step the job depends on is ready. Run numbers the
+// requests for the same job; reports for an older run are rejected. Params
+// carries job-specific options, such as a thumbnail timestamp.
+type JobRequested struct {
+	VideoID     string `json:"video_id"`
+	Job         string `json:"job"`
+	Run         int    `json:"run"`
+	Pipeline    string `json:"pipeline"`
+	Bucket      string `json:"bucket"`
+	Object      string `json:"object"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+
+	Params map[string]string `json:"params,omitempty"`
+}
+
+func DecodeJobRequested(body []byte) (Envelope, JobRequested, error) {
+	var data JobRequested
+	env, err := decodeData(body, TypeJobRequested, JobRequestedVersion, &data)
+	return env, data, err
+}
+
+// JobCanceled tells workers to stop a job. Runs up to and including Run are
+// canceled.
+type JobCanceled struct {
+	VideoID string `json:"video_id"`
+	Job     string `json:"job"`
+	Run     int    `json:"run"`
+	Reason  string `json:"reason"`
+}
+
+func DecodeJobCanceled(body []byte) (Envelope, JobCanceled, error) {
+	var data JobCanceled
+	env, err := decodeData(body, TypeJobCanceled, JobCanceledVersion, &data)
+	return env, data, err
+}
+
+// decodeData decodes an envelope of eventType into v. Unlike the original
+// events, these never had a legacy bare form.
+func decodeData(body []byte, eventType string, maxVersion int, v interface{}) (Envelope, error) {
+	env, err := Decode(body)
+	if err != nil {
+		return env, err
+	}
+	if env.Type == "" {
+		return env, fmt.Errorf("%w: missing, want %q", ErrWrongType, eventType)
+	}
+	if err := env.check(eventType, maxVersion); err != nil {
+		return env, err
+	}
+	if err := json.Unmarshal(env.Data, v); err != nil {
+		return env, fmt.Errorf("events: decode %s data: %w", eventType, err)
+	}
+	return env, nil
+}
@@ -0,0 +1,107 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDecodeVideoUploaded(t *testing.T) {
+	env, err := New("e1", TypeVideoUploaded, VideoUploadedVersion, "v1", "", VideoUploaded{Bucket: "videos", Object: "v1.mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enveloped, _ := json.Marshal(env)
+	newer := env
+	newer.SchemaVersion = VideoUploadedVersion + 1
+	future, _ := json.Marshal(newer)
+	deleted, _ := json.Marshal(Envelope{SpecVersion: SpecVersion, Type: TypeVideoDeleted, SchemaVersion: 1, Data: json.RawMessage(`{}`)})
+
+	for _, tc := range []struct {
+		name    string
+		body    string
+		want    VideoUploaded
+		version int
+		err     error
+	}{
+		{
+			name:    "envelope",
+			body:    string(enveloped),
+			want:    VideoUploaded{VideoID: "v1", Bucket: "videos", Object: "v1.mp4"},
+			version: VideoUploadedVersion,
+		},
+		{
+			name: "legacy bare JSON",
+			body: `{"video_id":"v2","bucket":"videos","object":"v2.mp4"}`,
+			want: VideoUploaded{VideoID: "v2", Bucket: "videos", Object: "v2.mp4"},
+		},
+		{
+			name: "legacy bare JSON without a video id",
+			body: `{"bucket":"videos","object":"old.mp4"}`,
+			want: VideoUploaded{Bucket: "videos", Object: "old.mp4"},
+		},
+		{name: "unsupported schema version", body: string(future), err: ErrUnsupportedVersion},
+		{name: "wrong type", body: string(deleted), err: ErrWrongType},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env, got, err := DecodeVideoUploaded([]byte(tc.body))
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("err = %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want || env.SchemaVersion != tc.version {
+				t.Errorf("decoded %+v v%d, want %+v v%d", got, env.SchemaVersion, tc.want, tc.version)
+			}
+		})
+	}
+}
+
+func TestDecodeVideoDeleted(t *testing.T) {
+	env, err := New("e1", TypeVideoDeleted, VideoDeletedVersion, "v1", "", VideoDeleted{VideoID: "v1", Bucket: "videos"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enveloped, _ := json.Marshal(env)
+	if !strings.Contains(string(env.Data), `"video_id":"v1"`) {
+		t.Errorf("data = %s, want video_id", env.Data)
+	}
+	withID, _ := json.Marshal(Envelope{SpecVersion: SpecVersion, Type: TypeVideoDeleted, SchemaVersion: 1, Data: json.RawMessage(`{"id":"v3"}`)})
+	spec2 := env
+	spec2.SpecVersion = "2.0"
+	future, _ := json.Marshal(spec2)
+
+	for _, tc := range []struct {
+		name string
+		body string
+		want string
+		err  error
+	}{
+		{name: "envelope", body: string(enveloped), want: "v1"},
+		{name: "legacy bare JSON", body: `{"id":"v2","bucket":"videos"}`, want: "v2"},
+		// Only legacy bodies name the video in id.
+		{name: "envelope with id", body: string(withID), want: ""},
+		{name: "unsupported specversion", body: string(future), err: ErrUnsupportedSpec},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, got, err := DecodeVideoDeleted([]byte(tc.body))
+			if tc.err != nil {
+				if !errors.Is(err, tc.err) {
+					t.Fatalf("err = %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.VideoID != tc.want {
+				t.Errorf("video id = %q, want %q", got.VideoID, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// TraceParentHeader is the W3C Trace Context header; envelopes carry the same
+// value in their traceparent attribute.
+const TraceParentHeader = "traceparent"
+
+type traceKey struct{}
+
+// ChildTraceParent returns a traceparent for a new span in the trace of
+// parent, or for a new trace if parent is empty or malformed.
+func ChildTraceParent(parent string) string {
+	traceID, flags := randomHex(16), "01"
+	if p := strings.Split(parent, "-"); len(p) == 4 && p[0] == "00" && len(p[1]) == 32 && len(p[3]) == 2 && isHex(p[1]) {
+		traceID, flags = p[1], p[3]
+	}
+	return "00-" + traceID + "-" + randomHex(8) + "-" + flags
+}
+
+// TraceID extracts the trace id from a traceparent, for logging.
+func TraceID(traceParent string) string {
+	if p := strings.Split(traceParent, "-"); len(p) == 4 {
+		return p[1]
+	}
+	return ""
+}
+
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceParent)
+}
+
+func TraceParentFrom(ctx context.Context) string {
+	tp, _ := ctx.Value(traceKey{}).(string)
+	return tp
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
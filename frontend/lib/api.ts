@@ -24,6 +24,10 @@ export interface ClusterStatus {
 export interface Video {
   id: string
   title: string
+  description: string
+  tags: string[]
+  visibility: 'public' | 'unlisted' | 'private'
+  version: number
   bucket: string
   object: string
   thumbnail_url: string
@@ -31,6 +35,36 @@ export interface Video {
   content_type: string
   uploaded_at: string
   resolutions: string[]
+  deleted_at?: string
+  probe?: ProbeInfo
+  status: VideoStatus
+  jobs?: Record<string, JobState>
+}
+
+export type VideoStatus = 'uploaded' | 'processing' | 'ready' | 'failed'
+
+export interface JobState {
+  status: 'queued' | 'processing' | 'ready' | 'failed'
+  error?: string
+  attempts: number
+  updated_at: string
+}
+
+export interface ProbeInfo {
+  duration_seconds: number
+  bit_rate: number
+  container: string
+  video_codec?: string
+  audio_codec?: string
+  width?: number
+  height?: number
+  frame_rate?: number
+  rotation?: number
+}
+
+export interface VideoDetail extends Video {
+  renditions: string[]
+  thumbnails: string[]
 }
 
 export interface UploadResponse {
@@ -55,7 +89,7 @@ export const getVideos = async (): Promise<Video[]> => {
   return response.data
 }
 
-export const getVideo = async (id: string): Promise<Video> => {
+export const getVideo = async (id: string): Promise<VideoDetail> => {
   const response = await api.get(`/videos/${id}`)
   return response.data
 }
@@ -79,6 +113,36 @@ export const uploadVideo = async (file: File, onProgress?: (progress: number) =>
   return response.data
 }
 
+export interface VideoPatch {
+  title?: string
+  description?: string
+  tags?: string[]
+  visibility?: 'public' | 'unlisted' | 'private'
+}
+
+// updateVideo sends If-Match with the version the caller last saw, so a
+// concurrent edit fails with 412 instead of being overwritten.
+export const updateVideo = async (id: string, patch: VideoPatch, version?: number): Promise<Video> => {
+  const response = await api.patch(`/videos/${id}`, patch, {
+    headers: version ? { 'If-Match': `"${version}"` } : undefined,
+  })
+  return response.data
+}
+
+export const deleteVideo = async (id: string, permanent = false): Promise<void> => {
+  await api.delete(`/videos/${id}`, { params: permanent ? { permanent: 'true' } : undefined })
+}
+
+export const restoreVideo = async (id: string): Promise<Video> => {
+  const response = await api.post(`/videos/${id}/restore`)
+  return response.data
+}
+
+export const getTrash = async (): Promise<Video[]> => {
+  const response = await api.get('/trash')
+  return response.data
+}
+
 export const getVideoStreamUrl = (id: string): string => {
   return `${api.defaults.baseURL}/videos/${id}/stream`
 }
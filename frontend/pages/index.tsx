@@ -24,6 +24,14 @@ interface Video {
   thumbnail_url: string
   uploaded_at: string
   size: number
+  status: 'uploaded' | 'processing' | 'ready' | 'failed'
+}
+
+const statusStyles: Record<Video['status'], string> = {
+  uploaded: 'bg-gray-500/20 text-gray-300',
+  processing: 'bg-yellow-500/20 text-yellow-300',
+  ready: 'bg-green-500/20 text-green-300',
+  failed: 'bg-red-500/20 text-red-300',
 }
 
 export default function Dashboard() {
@@ -51,7 +59,7 @@ export default function Dashboard() {
         setMetrics({
           totalVideos: videosData.length,
           totalStorage,
-          processingQueue: Math.floor(Math.random() * 5),
+          processingQueue: videosData.filter((video) => video.status === 'uploaded' || video.status === 'processing').length,
           uptime: Date.now() - new Date('2025-08-13').getTime()
         })
       } catch (error) {
@@ -284,7 +292,12 @@ export default function Dashboard() {
                       </div>
                     </div>
                     <div className="p-4">
-                      <h3 className="font-medium text-white truncate mb-2">{video.title}</h3>
+                      <div className="flex items-center justify-between gap-2 mb-2">
+                        <h3 className="font-medium text-white truncate">{video.title}</h3>
+                        <span className={`text-xs px-2 py-0.5 rounded-full capitalize ${statusStyles[video.status] ?? statusStyles.uploaded}`}>
+                          {video.status}
+                        </span>
+                      </div>
                       <div className="space-y-1 mb-3">
                         <p className="text-sm text-gray-300">
                           {new Date(video.uploaded_at).toLocaleDateString()}
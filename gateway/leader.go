@@ -117,7 +117,30 @@ func (cfg Config) GetClusterStatus() ClusterStatus {
 	}
 }
 
+var (
+	proxyClient = &http.Client{Timeout: 30 * time.Second}
+
+	// streamClient has no overall timeout, since a video response can
+	// legitimately take as long as the viewer keeps watching; only the wait
+	// for response headers is bounded.
+	streamClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			ResponseHeaderTimeout: 30 * time.Second,
+		},
+	}
+)
+
 func (cfg Config) ProxyToLeader(w http.ResponseWriter, r *http.Request) {
+	cfg.proxyToLeader(w, r, proxyClient)
+}
+
+// ProxyStreamToLeader proxies long-lived media responses.
+func (cfg Config) ProxyStreamToLeader(w http.ResponseWriter, r *http.Request) {
+	cfg.proxyToLeader(w, r, streamClient)
+}
+
+func (cfg Config) proxyToLeader(w http.ResponseWriter, r *http.Request, client *http.Client) {
 	leader, err := cfg.DiscoverLeader()
 	if err != nil {
 		http.Error(w, "No leader available: "+err.Error(), http.StatusServiceUnavailable)
@@ -129,7 +152,7 @@ func (cfg Config) ProxyToLeader(w http.ResponseWriter, r *http.Request) {
 		targetURL += "?" + r.URL.RawQuery
 	}
 	
-	proxyReq, err := http.NewRequest(r.Method, targetURL, r.Body)
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL, r.Body)
 	if err != nil {
 		http.Error(w, "Failed to create proxy request", http.StatusInternalServerError)
 		return
@@ -141,7 +164,6 @@ func (cfg Config) ProxyToLeader(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	
-	client := &http.Client{Timeout: 30 * time.Second}
 	resp, err := client.Do(proxyReq)
 	if err != nil {
 		http.Error(w, "Proxy request failed: "+err.Error(), http.StatusBadGateway)
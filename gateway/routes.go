@@ -24,12 +24,24 @@ func SetupRoutes(cfg Config) *mux.Router {
 	r.HandleFunc("/upload", cfg.ProxyToLeader).Methods("POST")
 	
 	r.HandleFunc("/videos", cfg.ProxyToLeader).Methods("GET", "POST")
-	r.HandleFunc("/videos/{id}", cfg.ProxyToLeader).Methods("GET", "PUT", "DELETE")
+	r.HandleFunc("/videos/{id}", cfg.ProxyToLeader).Methods("GET", "PUT", "PATCH", "DELETE")
+	r.HandleFunc("/videos/{id}/restore", cfg.ProxyToLeader).Methods("POST")
+	r.HandleFunc("/videos/{id}/jobs", cfg.ProxyToLeader).Methods("GET")
+	r.HandleFunc("/videos/{id}/reprocess", cfg.ProxyToLeader).Methods("POST")
+	r.HandleFunc("/videos/{id}/cancel", cfg.ProxyToLeader).Methods("POST")
+	r.HandleFunc("/trash", cfg.ProxyToLeader).Methods("GET")
+	r.HandleFunc("/pipelines", cfg.ProxyToLeader).Methods("GET")
+	r.HandleFunc("/pipelines/{name}", cfg.ProxyToLeader).Methods("GET", "PUT", "DELETE")
 	
-	r.HandleFunc("/videos/{id}/stream", cfg.ProxyToLeader).Methods("GET")
-	r.HandleFunc("/videos/{id}/thumbnail", cfg.ProxyToLeader).Methods("GET")
+	r.HandleFunc("/videos/{id}/stream", cfg.ProxyStreamToLeader).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/thumbnail", cfg.ProxyToLeader).Methods("GET", "HEAD", "PUT")
+	r.HandleFunc("/videos/{id}/storyboard.vtt", cfg.ProxyToLeader).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/storyboard/{name}", cfg.ProxyToLeader).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/hls/{path:.+}", cfg.ProxyStreamToLeader).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/dash/manifest.mpd", cfg.ProxyToLeader).Methods("GET", "HEAD")
 	
 	r.PathPrefix("/raft/").HandlerFunc(cfg.ProxyToLeader)
+	r.PathPrefix("/internal/").HandlerFunc(cfg.ProxyToLeader)
 	
 	return r
 }
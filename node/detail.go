@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"platform/storage"
+)
+
+// VideoDetail is the single-video view: the stored record plus what the
+// processors have produced for it so far.
+type VideoDetail struct {
+	VideoMetadata
+	Renditions []string `json:"renditions"`
+	Thumbnails []string `json:"thumbnails"`
+}
+
+func BuildVideoDetail(ctx context.Context, meta VideoMetadata) VideoDetail {
+	return VideoDetail{
+		VideoMetadata: meta,
+		Renditions:    append([]string{}, meta.Resolutions...),
+		Thumbnails:    thumbnailKeys(ctx, meta),
+	}
+}
+
+// thumbnailKeys lists the thumbnail objects that exist for meta: the reported
+// variants if there are any, or else whatever storage holds. Storage errors
+// are logged and treated as "none yet" so the record is still served.
+func thumbnailKeys(ctx context.Context, meta VideoMetadata) []string {
+	if len(meta.ThumbnailVariants) > 0 {
+		keys := make([]string, len(meta.ThumbnailVariants))
+		for i, v := range meta.ThumbnailVariants {
+			keys[i] = v.Key
+		}
+		return keys
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	keys := []string{}
+
+	objs, err := objectStore.List(ctx, meta.Bucket, DerivedPrefix(meta.ID)+"thumbnails/")
+	if err != nil {
+		log.Printf("list thumbnails for %s: %v", meta.ID, err)
+	}
+	for _, obj := range objs {
+		keys = append(keys, obj.Key)
+	}
+
+	legacy := legacyThumbnailKey(meta.Object)
+	if _, err := objectStore.Stat(ctx, meta.Bucket, legacy); err == nil {
+		keys = append(keys, legacy)
+	} else if !errors.Is(err, storage.ErrObjectNotFound) {
+		log.Printf("stat thumbnail for %s: %v", meta.ID, err)
+	}
+
+	return keys
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"events"
+)
+
+// idempotencyKeyTTL bounds how long a recorded Idempotency-Key keeps
+// deduplicating retried uploads.
+const idempotencyKeyTTL = 24 * time.Hour
+
+var (
+	ErrNotLeader       = errors.New("not the leader")
+	ErrVideoNotFound   = errors.New("video not found")
+	ErrVersionConflict = errors.New("video version conflict")
+	ErrStaleJobRun     = errors.New("job run is canceled or superseded")
+	ErrUnknownJob      = errors.New("unknown job type")
+	ErrNotInTrash      = errors.New("video is not in the trash")
+)
+
+type CommandType string
+
+const (
+	CmdStoreVideo     CommandType = "store_video"
+	CmdAckOutbox      CommandType = "ack_outbox"
+	CmdDeleteVideo    CommandType = "delete_video"
+	CmdRestoreVideo   CommandType = "restore_video"
+	CmdPurgeVideo     CommandType = "purge_video"
+	CmdUpdateVideo    CommandType = "update_video"
+	CmdSetRenditions  CommandType = "set_renditions"
+	CmdSetProbe       CommandType = "set_probe"
+	CmdSetJobStatus   CommandType = "set_job_status"
+	CmdPutPipeline    CommandType = "put_pipeline"
+	CmdDeletePipeline CommandType = "delete_pipeline"
+	CmdReprocessJobs  CommandType = "reprocess_jobs"
+	CmdCancelJobs     CommandType = "cancel_jobs"
+	CmdSetThumbnailAt CommandType = "set_thumbnail_at"
+	CmdSetThumbnails  CommandType = "set_thumbnails"
+)
+
+// Command is a single state machine operation. Everything apply needs must be
+// carried in the command itself (including the timestamp) so that applying
+// the same command on another node would reach the same state.
+type Command struct {
+	Type           CommandType    `json:"type"`
+	Timestamp      time.Time      `json:"timestamp"`
+	VideoID        string         `json:"video_id,omitempty"`
+	Video          *VideoMetadata `json:"video,omitempty"`
+	IdempotencyKey string         `json:"idempotency_key,omitempty"`
+
+	// Patch is applied by update_video. A non-zero ExpectedVersion makes the
+	// update conditional on the video still being at that version.
+	Patch           *VideoPatch `json:"patch,omitempty"`
+	ExpectedVersion int         `json:"expected_version,omitempty"`
+
+	// Renditions and Formats list the transcoded variants and streaming
+	// formats (hls, dash) available for VideoID.
+	Renditions []string `json:"renditions,omitempty"`
+	Formats    []string `json:"formats,omitempty"`
+
+	Probe *ProbeInfo `json:"probe,omitempty"`
+
+	// ThumbnailAt overrides the thumbnail frame time; nil clears it.
+	ThumbnailAt *float64           `json:"thumbnail_at,omitempty"`
+	Thumbnails  []ThumbnailVariant `json:"thumbnails,omitempty"`
+
+	JobType string    `json:"job_type,omitempty"`
+	Job     *JobState `json:"job,omitempty"`
+
+	// JobTypes selects the jobs to reprocess or cancel; empty means all.
+	JobTypes []string `json:"job_types,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+
+	Pipeline     *Pipeline `json:"pipeline,omitempty"`
+	PipelineName string    `json:"pipeline_name,omitempty"`
+
+	// Events are enqueued in the outbox atomically with the rest of the
+	// command; EventIDs names outbox entries that have been delivered.
+	Events   []OutboxEvent `json:"events,omitempty"`
+	EventIDs []string      `json:"event_ids,omitempty"`
+
+	// TraceParent is carried into events raised while applying the command.
+	TraceParent string `json:"trace_parent,omitempty"`
+}
+
+// ApplyResult is what a committed command produced. Duplicate is set when an
+// idempotent command matched an earlier one and the state was left unchanged.
+type ApplyResult struct {
+	Video     VideoMetadata
+	Duplicate bool
+}
+
+type idempotencyRecord struct {
+	VideoID    string
+	RecordedAt time.Time
+}
+
+// Propose applies cmd to the leader's state machine. Commands are not
+// replicated to peers; only the leader holds state.
+func (r *RaftNode) Propose(cmd Command) (ApplyResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state != Leader {
+		return ApplyResult{}, ErrNotLeader
+	}
+	if cmd.Timestamp.IsZero() {
+		cmd.Timestamp = time.Now()
+	}
+
+	return r.apply(cmd)
+}
+
+// apply must be called with r.mu held.
+func (r *RaftNode) apply(cmd Command) (ApplyResult, error) {
+	switch cmd.Type {
+	case CmdStoreVideo:
+		return r.applyStoreVideo(cmd)
+	case CmdAckOutbox:
+		return r.applyAckOutbox(cmd)
+	case CmdDeleteVideo:
+		return r.applyDeleteVideo(cmd)
+	case CmdRestoreVideo:
+		return r.applyRestoreVideo(cmd)
+	case CmdPurgeVideo:
+		return r.applyPurgeVideo(cmd)
+	case CmdUpdateVideo:
+		return r.applyUpdateVideo(cmd)
+	case CmdSetRenditions:
+		return r.applySetRenditions(cmd)
+	case CmdSetProbe:
+		return r.applySetProbe(cmd)
+	case CmdSetJobStatus:
+		return r.applySetJobStatus(cmd)
+	case CmdPutPipeline:
+		return r.applyPutPipeline(cmd)
+	case CmdDeletePipeline:
+		return r.applyDeletePipeline(cmd)
+	case CmdReprocessJobs:
+		return r.applyReprocessJobs(cmd)
+	case CmdCancelJobs:
+		return r.applyCancelJobs(cmd)
+	case CmdSetThumbnailAt:
+		return r.applySetThumbnailAt(cmd)
+	case CmdSetThumbnails:
+		return r.applySetThumbnails(cmd)
+	default:
+		return ApplyResult{}, fmt.Errorf("unknown command type %q", cmd.Type)
+	}
+}
+
+func (r *RaftNode) applyStoreVideo(cmd Command) (ApplyResult, error) {
+	if cmd.Video == nil {
+		return ApplyResult{}, fmt.Errorf("store_video: missing video")
+	}
+
+	r.pruneIdempotencyKeys(cmd.Timestamp)
+
+	if cmd.IdempotencyKey != "" {
+		if rec, ok := r.idempotencyKeys[cmd.IdempotencyKey]; ok {
+			if existing, ok := r.videos[rec.VideoID]; ok {
+				return ApplyResult{Video: existing, Duplicate: true}, nil
+			}
+		}
+	}
+
+	// cmd.Video belongs to the proposer, so it must stay as it was proposed.
+	meta := *cmd.Video
+	meta.Jobs = copyJobs(meta.Jobs)
+	if meta.Version == 0 {
+		meta.Version = 1
+	}
+	r.advancePipeline(cmd, &meta)
+	meta.Status = deriveStatus(meta.Jobs)
+	r.videos[meta.ID] = meta
+	r.enqueueEvents(cmd.Events)
+	if cmd.IdempotencyKey != "" {
+		r.idempotencyKeys[cmd.IdempotencyKey] = idempotencyRecord{
+			VideoID:    meta.ID,
+			RecordedAt: cmd.Timestamp,
+		}
+	}
+
+	return ApplyResult{Video: meta}, nil
+}
+
+// applyDeleteVideo moves a video to the trash. The objects stay in storage
+// until the trash purger hard-deletes them.
+func (r *RaftNode) applyDeleteVideo(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+	if meta.DeletedAt != nil {
+		return ApplyResult{Video: meta, Duplicate: true}, nil
+	}
+
+	deletedAt := cmd.Timestamp
+	meta.DeletedAt = &deletedAt
+	meta.Jobs = copyJobs(meta.Jobs)
+	for _, jobType := range videoPipeline(meta).stepNames() {
+		r.cancelJob(cmd, &meta, jobType, "video deleted")
+	}
+	meta.Status = deriveStatus(meta.Jobs)
+	r.videos[meta.ID] = meta
+	r.enqueueEvents(cmd.Events)
+
+	return ApplyResult{Video: meta}, nil
+}
+
+func (r *RaftNode) applyRestoreVideo(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+	if meta.DeletedAt == nil {
+		return ApplyResult{Video: meta}, ErrNotInTrash
+	}
+
+	// Restoring resumes canceled work.
+	meta.DeletedAt = nil
+	meta.Jobs = copyJobs(meta.Jobs)
+	for jobType, job := range meta.Jobs {
+		if job.Status == JobCanceled {
+			job.Status, job.Error, job.UpdatedAt = JobPending, "", cmd.Timestamp
+			meta.Jobs[jobType] = job
+		}
+	}
+	r.advancePipeline(cmd, &meta)
+	meta.Status = deriveStatus(meta.Jobs)
+	r.videos[meta.ID] = meta
+
+	return ApplyResult{Video: meta}, nil
+}
+
+func (r *RaftNode) applyPurgeVideo(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+
+	delete(r.videos, meta.ID)
+	for key, rec := range r.idempotencyKeys {
+		if rec.VideoID == meta.ID {
+			delete(r.idempotencyKeys, key)
+		}
+	}
+	r.dropVideoEvents(meta.ID)
+
+	return ApplyResult{Video: meta}, nil
+}
+
+func (r *RaftNode) applyUpdateVideo(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok || meta.DeletedAt != nil {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+	if cmd.Patch == nil {
+		return ApplyResult{}, fmt.Errorf("update_video: missing patch")
+	}
+	if cmd.ExpectedVersion != 0 && cmd.ExpectedVersion != meta.Version {
+		return ApplyResult{Video: meta}, ErrVersionConflict
+	}
+
+	cmd.Patch.applyTo(&meta)
+	meta.Version++
+	r.videos[meta.ID] = meta
+	r.enqueueEvents(cmd.Events)
+	r.raiseEvent(cmd, events.TypeVideoUpdated, events.VideoUpdatedVersion, meta.ID, events.VideoUpdated{
+		VideoID: meta.ID,
+		Version: meta.Version,
+		Fields:  cmd.Patch.fields(),
+	})
+
+	return ApplyResult{Video: meta}, nil
+}
+
+func (r *RaftNode) applySetRenditions(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+
+	meta.Resolutions = append([]string{"original"}, cmd.Renditions...)
+	meta.StreamingFormats = cmd.Formats
+	r.videos[meta.ID] = meta
+
+	return ApplyResult{Video: meta}, nil
+}
+
+func (r *RaftNode) applySetProbe(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+	if cmd.Probe == nil {
+		return ApplyResult{}, fmt.Errorf("set_probe: missing probe")
+	}
+
+	probe := *cmd.Probe
+	meta.Probe = &probe
+	r.videos[meta.ID] = meta
+
+	return ApplyResult{Video: meta}, nil
+}
+
+func (r *RaftNode) pruneIdempotencyKeys(now time.Time) {
+	for key, rec := range r.idempotencyKeys {
+		if now.Sub(rec.RecordedAt) > idempotencyKeyTTL {
+			delete(r.idempotencyKeys, key)
+		}
+	}
+}
+
+// LookupIdempotencyKey returns the video previously created with key, if the
+// key is still within its retention window.
+func (r *RaftNode) LookupIdempotencyKey(key string) (VideoMetadata, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rec, ok := r.idempotencyKeys[key]
+	if !ok || time.Since(rec.RecordedAt) > idempotencyKeyTTL {
+		return VideoMetadata{}, false
+	}
+
+	meta, ok := r.videos[rec.VideoID]
+	return meta, ok
+}
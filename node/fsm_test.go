@@ -0,0 +1,162 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"errors"
+)
+
+// newTestRaft returns a single-node cluster that is already leader.
+func newTestRaft() *RaftNode {
+	return &RaftNode{
+		id:              "node-1",
+		state:           Leader,
+		videos:          make(map[string]VideoMetadata),
+		idempotencyKeys: make(map[string]idempotencyRecord),
+		outbox:          make(map[string]OutboxEvent),
+		pipelines:       make(map[string]Pipeline),
+	}
+}
+
+func testVideo(id string) *VideoMetadata {
+	p := defaultPipeline([]string{"probe", "thumbnail"})
+	return &VideoMetadata{
+		ID:       id,
+		Bucket:   "videos",
+		Object:   id + ".mp4",
+		Pipeline: &p,
+		Jobs:     NewJobStates(p.stepNames(), time.Now()),
+	}
+}
+
+func TestStoreVideoLeavesCommandUnchanged(t *testing.T) {
+	r := newTestRaft()
+	video := testVideo("v1")
+	store := Command{Type: CmdStoreVideo, Timestamp: time.Now(), Video: video}
+
+	res, err := r.Propose(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Video.Version != 1 || res.Video.Jobs["probe"].Status != JobQueued {
+		t.Fatalf("stored video = %+v, want version 1 with probe queued", res.Video)
+	}
+
+	job := &JobState{Status: JobProcessing}
+	if _, err := r.Propose(Command{Type: CmdSetJobStatus, VideoID: "v1", JobType: "probe", Job: job}); err != nil {
+		t.Fatal(err)
+	}
+	if video.Version != 0 || video.Status != "" {
+		t.Errorf("proposed video changed: version %d, status %q", video.Version, video.Status)
+	}
+	for name, job := range video.Jobs {
+		if job.Status != JobPending || job.Run != 0 {
+			t.Errorf("proposed job %s changed: %+v", name, job)
+		}
+	}
+
+	// Applying the command again must produce the same state.
+	replica := newTestRaft()
+	replayed, err := replica.apply(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayed.Video.Jobs["probe"].Run != 1 {
+		t.Errorf("replayed probe job = %+v, want run 1", replayed.Video.Jobs["probe"])
+	}
+}
+
+func TestStoreVideoDuplicateIdempotencyKey(t *testing.T) {
+	r := newTestRaft()
+
+	first, err := r.Propose(Command{Type: CmdStoreVideo, Video: testVideo("v1"), IdempotencyKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Duplicate {
+		t.Fatal("first upload reported as a duplicate")
+	}
+	pending := len(r.outbox)
+
+	second, err := r.Propose(Command{Type: CmdStoreVideo, Video: testVideo("v2"), IdempotencyKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.Duplicate || second.Video.ID != "v1" {
+		t.Fatalf("second upload = %s (duplicate %t), want a duplicate of v1", second.Video.ID, second.Duplicate)
+	}
+	if _, err := r.GetVideoMetadata("v2"); err == nil {
+		t.Error("duplicate upload was stored")
+	}
+	if len(r.outbox) != pending {
+		t.Errorf("duplicate upload raised %d events", len(r.outbox)-pending)
+	}
+	if meta, ok := r.LookupIdempotencyKey("key"); !ok || meta.ID != "v1" {
+		t.Errorf("LookupIdempotencyKey = %s, %t; want v1", meta.ID, ok)
+	}
+}
+
+func TestIdempotencyKeyExpires(t *testing.T) {
+	r := newTestRaft()
+	old := time.Now().Add(-idempotencyKeyTTL - time.Minute)
+
+	if _, err := r.Propose(Command{Type: CmdStoreVideo, Video: testVideo("v1"), IdempotencyKey: "key", Timestamp: old}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.LookupIdempotencyKey("key"); ok {
+		t.Error("expired key still found")
+	}
+
+	res, err := r.Propose(Command{Type: CmdStoreVideo, Video: testVideo("v2"), IdempotencyKey: "key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Duplicate || res.Video.ID != "v2" {
+		t.Fatalf("upload with an expired key = %s (duplicate %t), want a new video", res.Video.ID, res.Duplicate)
+	}
+	if meta, ok := r.LookupIdempotencyKey("key"); !ok || meta.ID != "v2" {
+		t.Errorf("LookupIdempotencyKey = %s, %t; want v2", meta.ID, ok)
+	}
+}
+
+func TestSetJobStatusAttempts(t *testing.T) {
+	r := newTestRaft()
+	if _, err := r.Propose(Command{Type: CmdStoreVideo, Video: testVideo("v1")}); err != nil {
+		t.Fatal(err)
+	}
+	set := func(jobType, status string, progress float64) (JobState, error) {
+		res, err := r.Propose(Command{Type: CmdSetJobStatus, VideoID: "v1", JobType: jobType,
+			Job: &JobState{Status: status, Run: 1, Progress: progress}})
+		return res.Video.Jobs[jobType], err
+	}
+
+	if _, err := set("transcode", JobProcessing, 0); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("report for a job the video lacks: err = %v, want %v", err, ErrUnknownJob)
+	}
+	if meta, _ := r.GetVideoMetadata("v1"); len(meta.Jobs) != 2 {
+		t.Errorf("jobs = %+v, want only probe and thumbnail", meta.Jobs)
+	}
+
+	steps := []struct {
+		status   string
+		progress float64
+		attempts int
+	}{
+		{JobProcessing, 0, 1},
+		{JobProcessing, 0.5, 1},
+		// A redelivered processing report is not a new attempt.
+		{JobProcessing, 0, 1},
+		{JobQueued, 0, 1},
+		{JobProcessing, 0, 2},
+	}
+	for _, step := range steps {
+		job, err := set("probe", step.status, step.progress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Attempts != step.attempts {
+			t.Errorf("after %s at %.1f: attempts = %d, want %d", step.status, step.progress, job.Attempts, step.attempts)
+		}
+	}
+}
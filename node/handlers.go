@@ -2,13 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"time"
 	"path/filepath"
 	"strings"
 	"fmt"
+
+	"events"
+
+	"github.com/gorilla/mux"
 )
 
+const maxIdempotencyKeyLen = 255
+
 func UploadHandler(cfg Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if !raftNode.IsLeader() {
@@ -16,7 +24,25 @@ func UploadHandler(cfg Config) http.HandlerFunc {
 			return
 		}
 		
-		meta, err := UploadToMinIO(cfg.MinIOBucket, r)
+		idemKey := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+		if len(idemKey) > maxIdempotencyKeyLen {
+			http.Error(w, "Idempotency-Key too long", http.StatusBadRequest)
+			return
+		}
+		if idemKey != "" {
+			if existing, ok := raftNode.LookupIdempotencyKey(idemKey); ok {
+				writeIdempotentReplay(w, existing)
+				return
+			}
+		}
+		
+		pipeline, err := raftNode.ResolvePipeline(r.URL.Query().Get("pipeline"), cfg.ProcessingJobs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		meta, err := UploadToStore(cfg.MinIOBucket, r)
 		if err != nil {
 			http.Error(w, "Upload failed: "+err.Error(), http.StatusBadRequest)
 			return
@@ -26,6 +52,8 @@ func UploadHandler(cfg Config) http.HandlerFunc {
 		videoMeta := VideoMetadata{
 			ID:          videoID,
 			Title:       extractTitle(meta.Object),
+			Tags:        []string{},
+			Visibility:  VisibilityPublic,
 			Bucket:      meta.Bucket,
 			Object:      meta.Object,
 			ThumbnailURL: fmt.Sprintf("/videos/%s/thumbnail", videoID),
@@ -33,29 +61,252 @@ func UploadHandler(cfg Config) http.HandlerFunc {
 			ContentType: meta.ContentType,
 			UploadedAt:  time.Now(),
 			Resolutions: []string{"original"},
+			Pipeline:    &pipeline,
+			Jobs:        NewJobStates(pipeline.stepNames(), time.Now()),
 		}
 		
-		if err := raftNode.StoreVideoMetadata(videoMeta); err != nil {
-			http.Error(w, "Failed to store metadata: "+err.Error(), http.StatusInternalServerError)
+		traceParent := events.ChildTraceParent(r.Header.Get(events.TraceParentHeader))
+		event, err := NewOutboxEvent(events.TypeVideoUploaded, events.VideoUploadedVersion, videoID, traceParent, events.VideoUploaded{
+			VideoID:     videoID,
+			Bucket:      meta.Bucket,
+			Object:      meta.Object,
+			Size:        meta.Size,
+			ContentType: meta.ContentType,
+		})
+		if err != nil {
+			http.Error(w, "Failed to encode message: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
 		
-		body, err := json.Marshal(meta)
+		res, err := raftNode.Propose(Command{
+			Type:           CmdStoreVideo,
+			Video:          &videoMeta,
+			IdempotencyKey: idemKey,
+			Events:         []OutboxEvent{event},
+		})
 		if err != nil {
-			http.Error(w, "Failed to encode message: "+err.Error(), http.StatusInternalServerError)
+			http.Error(w, "Failed to store metadata: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		if err := PublishMessage("video_uploaded", body); err != nil {
-			http.Error(w, "Failed to publish event: "+err.Error(), http.StatusInternalServerError)
+		if res.Duplicate {
+			// A concurrent retry with the same key committed first; drop our copy.
+			if err := RemoveFromStore(meta.Bucket, meta.Object); err != nil {
+				log.Printf("failed to remove duplicate upload %s/%s: %v", meta.Bucket, meta.Object, err)
+			}
+			writeIdempotentReplay(w, res.Video)
 			return
 		}
+		outboxRelay.Notify()
 
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(videoMeta)
 	}
 }
 
+func GetVideoHandler(w http.ResponseWriter, r *http.Request) {
+	meta, err := raftNode.GetVideoMetadata(mux.Vars(r)["id"])
+	if err != nil || !viewable(meta) {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+	
+	// The ETag tracks the editable record only, for use with If-Match; the
+	// derived fields change without a version bump, so no 304 handling here.
+	w.Header().Set("ETag", videoETag(meta))
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(BuildVideoDetail(r.Context(), meta))
+}
+
+// DeleteVideoHandler moves a video to the trash. With ?permanent=true the
+// objects are purged and the record removed immediately.
+func DeleteVideoHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+	
+	id := mux.Vars(r)["id"]
+	meta, err := raftNode.GetVideoMetadata(id)
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	
+	traceParent := events.ChildTraceParent(r.Header.Get(events.TraceParentHeader))
+	event, err := NewOutboxEvent(events.TypeVideoDeleted, events.VideoDeletedVersion, meta.ID, traceParent, events.VideoDeleted{
+		VideoID:   meta.ID,
+		Bucket:    meta.Bucket,
+		Object:    meta.Object,
+		DeletedAt: time.Now(),
+	})
+	if err != nil {
+		http.Error(w, "Failed to encode message: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	
+	// A permanent delete goes through the trash first, so running jobs are
+	// canceled and workers hear about the deletion before the purge.
+	res, err := raftNode.Propose(Command{
+		Type:        CmdDeleteVideo,
+		VideoID:     id,
+		Events:      []OutboxEvent{event},
+		TraceParent: traceParent,
+	})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	outboxRelay.Notify()
+	
+	if r.URL.Query().Get("permanent") == "true" {
+		if err := HardDeleteVideo(raftNode, res.Video); err != nil {
+			writeCommandError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res.Video)
+}
+
+func RestoreVideoHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+	
+	res, err := raftNode.Propose(Command{Type: CmdRestoreVideo, VideoID: mux.Vars(r)["id"]})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res.Video)
+}
+
+// UpdateVideoHandler serves PATCH (partial) and PUT (full replacement of the
+// editable fields). If-Match is required and makes the update conditional on
+// the video's current ETag, or unconditional with "*".
+func UpdateVideoHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+	
+	var patch VideoPatch
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patch); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	
+	if r.Method == http.MethodPut {
+		if patch.Title == nil {
+			http.Error(w, "title is required", http.StatusBadRequest)
+			return
+		}
+		if patch.Description == nil {
+			patch.Description = new(string)
+		}
+		if patch.Tags == nil {
+			patch.Tags = &[]string{}
+		}
+		if patch.Visibility == nil {
+			v := VisibilityPublic
+			patch.Visibility = &v
+		}
+	}
+	if err := patch.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	
+	ifMatch := r.Header.Get("If-Match")
+	if strings.TrimSpace(ifMatch) == "" {
+		http.Error(w, "If-Match is required; send the video's ETag, or * to overwrite", http.StatusPreconditionRequired)
+		return
+	}
+	expected, ok := parseIfMatch(ifMatch)
+	if !ok {
+		http.Error(w, "If-Match does not match any version", http.StatusPreconditionFailed)
+		return
+	}
+	
+	res, err := raftNode.Propose(Command{
+		Type:            CmdUpdateVideo,
+		VideoID:         mux.Vars(r)["id"],
+		Patch:           &patch,
+		ExpectedVersion: expected,
+		TraceParent:     events.ChildTraceParent(r.Header.Get(events.TraceParentHeader)),
+	})
+	if err != nil {
+		if errors.Is(err, ErrVersionConflict) {
+			w.Header().Set("ETag", videoETag(res.Video))
+		}
+		writeCommandError(w, err)
+		return
+	}
+	
+	outboxRelay.Notify()
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", videoETag(res.Video))
+	_ = json.NewEncoder(w).Encode(res.Video)
+}
+
+func videoETag(meta VideoMetadata) string {
+	return fmt.Sprintf("\"%d\"", meta.Version)
+}
+
+// parseIfMatch converts an If-Match header into the version the update is
+// conditional on. "*" yields 0, i.e. unconditional.
+func parseIfMatch(header string) (int, bool) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return 0, true
+	}
+	tag := strings.TrimPrefix(header, "W/")
+	tag = strings.Trim(tag, "\"")
+	var version int
+	if _, err := fmt.Sscanf(tag, "%d", &version); err != nil || version <= 0 {
+		return 0, false
+	}
+	return version, true
+}
+
+func writeCommandError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrVideoNotFound), errors.Is(err, ErrPipelineNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrVersionConflict):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case errors.Is(err, ErrStaleJobRun), errors.Is(err, ErrNotInTrash):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrUnknownJob), errors.Is(err, ErrThumbnailPastEnd):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrNotLeader):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writeIdempotentReplay answers a retried upload with the video it created,
+// or 410 once that video has been deleted.
+func writeIdempotentReplay(w http.ResponseWriter, meta VideoMetadata) {
+	if meta.DeletedAt != nil {
+		http.Error(w, "the video uploaded with this Idempotency-Key has been deleted", http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	_ = json.NewEncoder(w).Encode(meta)
+}
+
 func generateVideoID(objectName string) string {
 	base := filepath.Base(objectName)
 	base = strings.TrimSuffix(base, filepath.Ext(base))
@@ -73,3 +324,19 @@ func extractTitle(objectName string) string {
 	
 	return title
 }
+
+// HealthHandler reports broker connectivity. The node keeps serving reads
+// while the broker is down and events queue in the outbox, so that is
+// reported as degraded rather than failing the check.
+func HealthHandler(w http.ResponseWriter, _ *http.Request) {
+	health := broker.Health()
+	status := "ok"
+	if !health.Connected {
+		status = "degraded"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": status,
+		"broker": health,
+	})
+}
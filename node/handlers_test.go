@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"platform/mq"
+	"platform/storage"
+)
+
+// startTestNode points the node's globals at a new leader, a local object
+// store and an in-memory broker, and serves the node's API.
+func startTestNode(t *testing.T, cfg Config) *httptest.Server {
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.EnsureBucket(context.Background(), cfg.MinIOBucket); err != nil {
+		t.Fatal(err)
+	}
+	objectStore = store
+	broker = mq.NewMemoryBroker()
+	raftNode = newTestRaft()
+	outboxRelay = StartOutboxRelay(raftNode)
+
+	srv := httptest.NewServer(newRouter(cfg))
+	relay := outboxRelay
+	t.Cleanup(func() {
+		srv.Close()
+		relay.Stop()
+		broker.Close()
+	})
+	return srv
+}
+
+func storeTestVideo(t *testing.T, id, visibility string) {
+	t.Helper()
+	video := testVideo(id)
+	video.Visibility = visibility
+	if _, err := raftNode.Propose(Command{Type: CmdStoreVideo, Video: video}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateVideoRequiresIfMatch(t *testing.T) {
+	srv := startTestNode(t, Config{MinIOBucket: "videos"})
+	storeTestVideo(t, "v1", VisibilityPublic)
+
+	for _, tc := range []struct {
+		method, ifMatch string
+		want            int
+	}{
+		{http.MethodPatch, "", http.StatusPreconditionRequired},
+		{http.MethodPut, "", http.StatusPreconditionRequired},
+		{http.MethodPatch, `"7"`, http.StatusPreconditionFailed},
+		{http.MethodPatch, `"1"`, http.StatusOK},
+		{http.MethodPut, "*", http.StatusOK},
+	} {
+		req, _ := http.NewRequest(tc.method, srv.URL+"/videos/v1", strings.NewReader(`{"title":"renamed"}`))
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s with If-Match %q = %d, want %d", tc.method, tc.ifMatch, resp.StatusCode, tc.want)
+		}
+	}
+}
+
+func TestPrivateVideosAreWithheld(t *testing.T) {
+	srv := startTestNode(t, Config{MinIOBucket: "videos"})
+	storeTestVideo(t, "public", VisibilityPublic)
+	storeTestVideo(t, "unlisted", VisibilityUnlisted)
+	storeTestVideo(t, "private", VisibilityPrivate)
+
+	resp, err := http.Get(srv.URL + "/videos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var videos []VideoMetadata
+	err = json.NewDecoder(resp.Body).Decode(&videos)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 1 || videos[0].ID != "public" {
+		t.Errorf("GET /videos listed %+v, want only the public video", videos)
+	}
+
+	for _, path := range []string{"", "/stream", "/thumbnail"} {
+		for _, id := range []string{"unlisted", "private"} {
+			resp, err := http.Get(srv.URL + "/videos/" + id + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			// The test videos have no media stored, so only the video
+			// lookup itself is checked.
+			withheld := resp.StatusCode == http.StatusNotFound && string(body) == "video not found\n"
+			if withheld != (id == "private") {
+				t.Errorf("GET /videos/%s%s = %d %q", id, path, resp.StatusCode, body)
+			}
+		}
+	}
+}
+
+func TestWorkerAPIRequiresToken(t *testing.T) {
+	cases := []struct {
+		token, auth string
+		want        int
+	}{
+		{"", "", http.StatusServiceUnavailable},
+		{"", "Bearer ", http.StatusServiceUnavailable},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		srv := startTestNode(t, Config{MinIOBucket: "videos", WorkerToken: c.token})
+		storeTestVideo(t, "v1", "")
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/internal/videos/v1/jobs/probe", strings.NewReader(`{"run":1,"status":"processing"}`))
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("token %q, Authorization %q: status %d, want %d", c.token, c.auth, resp.StatusCode, c.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// hlsContentTypes covers what the transcoder writes under the hls/ prefix.
+var hlsContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+}
+
+func HLSPrefix(videoID string) string {
+	return DerivedPrefix(videoID) + "hls/"
+}
+
+// DASHPrefix holds the MPD only; with CMAF packaging its BaseURL points at
+// the segments under HLSPrefix, which HLSHandler serves.
+func DASHPrefix(videoID string) string {
+	return DerivedPrefix(videoID) + "dash/"
+}
+
+// HLSHandler serves /videos/{id}/hls/{path}. The master playlist references
+// variant playlists and segments by relative path, so everything below the
+// video's hls/ prefix is reachable through this one route.
+func HLSHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meta, err := raftNode.GetVideoMetadata(vars["id"])
+	if err != nil || !viewable(meta) {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+
+	rel := vars["path"]
+	if rel == "" || strings.Contains(rel, "..") {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+	rel = strings.TrimPrefix(path.Clean("/"+rel), "/")
+
+	contentType, ok := hlsContentTypes[path.Ext(rel)]
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	// Segments never change once written; playlists may be replaced if the
+	// video is re-transcoded.
+	if path.Ext(rel) == ".m3u8" {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	}
+	serveObject(w, r, meta.Bucket, HLSPrefix(meta.ID)+rel, contentType)
+}
+
+func DASHManifestHandler(w http.ResponseWriter, r *http.Request) {
+	meta, err := raftNode.GetVideoMetadata(mux.Vars(r)["id"])
+	if err != nil || !viewable(meta) {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	serveObject(w, r, meta.Bucket, DASHPrefix(meta.ID)+"manifest.mpd", "application/dash+xml")
+}
+
+type renditionsReport struct {
+	Renditions []string `json:"renditions"`
+	Formats    []string `json:"formats"`
+}
+
+// RenditionsCallbackHandler lets the transcoder report which renditions and
+// formats it packaged for a video.
+func RenditionsCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+
+	var report renditionsReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&report); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := raftNode.Propose(Command{
+		Type:       CmdSetRenditions,
+		VideoID:    mux.Vars(r)["id"],
+		Renditions: report.Renditions,
+		Formats:    report.Formats,
+	})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res.Video)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"events"
+
+	"github.com/gorilla/mux"
+)
+
+// JobInfo is one entry of GET /videos/{id}/jobs.
+type JobInfo struct {
+	Type      string   `json:"type"`
+	DependsOn []string `json:"depends_on,omitempty"`
+	JobState
+}
+
+// videoJobs lists meta's jobs in pipeline order, followed by any jobs
+// reported outside the pipeline.
+func videoJobs(meta VideoMetadata) []JobInfo {
+	jobs := make([]JobInfo, 0, len(meta.Jobs))
+	seen := make(map[string]bool, len(meta.Jobs))
+	for _, step := range videoPipeline(meta).Steps {
+		if job, ok := meta.Jobs[step.Name]; ok {
+			jobs = append(jobs, JobInfo{Type: step.Name, DependsOn: step.DependsOn, JobState: job})
+			seen[step.Name] = true
+		}
+	}
+
+	var extra []string
+	for name := range meta.Jobs {
+		if !seen[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		jobs = append(jobs, JobInfo{Type: name, JobState: meta.Jobs[name]})
+	}
+	return jobs
+}
+
+// selectJobs resolves the job types a reprocess or cancel request names,
+// adding the steps that depend on them. No names selects every step.
+func selectJobs(meta VideoMetadata, names []string) ([]string, error) {
+	pipeline := videoPipeline(meta)
+	if len(names) == 0 {
+		return pipeline.stepNames(), nil
+	}
+	for _, name := range names {
+		if _, ok := meta.Jobs[name]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownJob, name)
+		}
+	}
+	return pipeline.withDependents(names), nil
+}
+
+// cancelJob marks an unfinished job canceled and, if it was dispatched,
+// tells workers to stop it. meta.Jobs must not be shared with the stored
+// video. Must be called with r.mu held.
+func (r *RaftNode) cancelJob(cmd Command, meta *VideoMetadata, jobType, reason string) {
+	job, ok := meta.Jobs[jobType]
+	if !ok {
+		return
+	}
+	switch job.Status {
+	case JobQueued, JobProcessing:
+		key := events.JobRoutingKey(events.TypeJobCanceled, jobType)
+		r.raiseRoutedEvent(cmd, key, events.TypeJobCanceled, events.JobCanceledVersion, meta.ID, false, events.JobCanceled{
+			VideoID: meta.ID,
+			Job:     jobType,
+			Run:     job.Run,
+			Reason:  reason,
+		})
+	case JobPending:
+	default:
+		return
+	}
+
+	job.Status = JobCanceled
+	job.Error = reason
+	job.UpdatedAt = cmd.Timestamp
+	meta.Jobs[jobType] = job
+}
+
+// applyReprocessJobs runs the selected jobs and their dependents again.
+// Runs still in flight are canceled first.
+func (r *RaftNode) applyReprocessJobs(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok || meta.DeletedAt != nil {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+	selected, err := selectJobs(meta, cmd.JobTypes)
+	if err != nil {
+		return ApplyResult{Video: meta}, err
+	}
+
+	r.reprocessJobs(cmd, &meta, selected)
+	r.videos[meta.ID] = meta
+
+	return ApplyResult{Video: meta}, nil
+}
+
+// reprocessJobs resets jobTypes to pending and dispatches those that can run.
+// Must be called with r.mu held.
+func (r *RaftNode) reprocessJobs(cmd Command, meta *VideoMetadata, jobTypes []string) {
+	meta.Jobs = copyJobs(meta.Jobs)
+	for _, jobType := range jobTypes {
+		r.cancelJob(cmd, meta, jobType, "reprocessed")
+		job := meta.Jobs[jobType]
+		job.Status = JobPending
+		job.Error = ""
+		job.Progress = 0
+		job.Result = nil
+		job.UpdatedAt = cmd.Timestamp
+		meta.Jobs[jobType] = job
+	}
+	r.advancePipeline(cmd, meta)
+	meta.Status = deriveStatus(meta.Jobs)
+}
+
+// applyCancelJobs cancels the selected jobs and their dependents. Finished
+// jobs are left as they are.
+func (r *RaftNode) applyCancelJobs(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok || meta.DeletedAt != nil {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+	selected, err := selectJobs(meta, cmd.JobTypes)
+	if err != nil {
+		return ApplyResult{Video: meta}, err
+	}
+
+	reason := cmd.Reason
+	if reason == "" {
+		reason = "canceled"
+	}
+	meta.Jobs = copyJobs(meta.Jobs)
+	for _, jobType := range selected {
+		r.cancelJob(cmd, &meta, jobType, reason)
+	}
+	meta.Status = deriveStatus(meta.Jobs)
+	r.videos[meta.ID] = meta
+
+	return ApplyResult{Video: meta}, nil
+}
+
+func VideoJobsHandler(w http.ResponseWriter, r *http.Request) {
+	meta, err := raftNode.GetVideoMetadata(mux.Vars(r)["id"])
+	if err != nil || meta.DeletedAt != nil {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(videoJobs(meta))
+}
+
+type jobsRequest struct {
+	Jobs   []string `json:"jobs"`
+	Reason string   `json:"reason"`
+}
+
+// ReprocessHandler serves POST /videos/{id}/reprocess. The body may list
+// job types as {"jobs": [...]}; without one every job runs again.
+func ReprocessHandler(w http.ResponseWriter, r *http.Request) {
+	proposeJobsCommand(w, r, CmdReprocessJobs)
+}
+
+// CancelJobsHandler serves POST /videos/{id}/cancel, which stops unfinished
+// jobs, all or those listed as for reprocessing, with an optional reason.
+func CancelJobsHandler(w http.ResponseWriter, r *http.Request) {
+	proposeJobsCommand(w, r, CmdCancelJobs)
+}
+
+func proposeJobsCommand(w http.ResponseWriter, r *http.Request, cmdType CommandType) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req jobsRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := raftNode.Propose(Command{
+		Type:        cmdType,
+		VideoID:     mux.Vars(r)["id"],
+		JobTypes:    req.Jobs,
+		Reason:      req.Reason,
+		TraceParent: events.ChildTraceParent(r.Header.Get(events.TraceParentHeader)),
+	})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	outboxRelay.Notify()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(videoJobs(res.Video))
+}
@@ -4,40 +4,84 @@ import (
 	"log"
 	"net/http"
 
+	"platform/mq"
+
 	"github.com/gorilla/mux"
 )
 
 func main() {
 	cfg := LoadConfig()
 
-	if err := InitMinIO(cfg); err != nil {
-		log.Fatalf("Failed to init MinIO: %v", err)
+	if err := InitStorage(cfg); err != nil {
+		log.Fatalf("Failed to init %s storage: %v", cfg.StorageBackend, err)
 	}
-	if err := InitRabbit(cfg); err != nil {
-		log.Fatalf("Failed to init RabbitMQ: %v", err)
+	if err := InitBroker(cfg); err != nil {
+		log.Fatalf("Failed to init %s broker: %v", cfg.BrokerBackend, err)
 	}
-	defer func() {
-		if rabbitCh != nil {
-			_ = rabbitCh.Close()
-		}
-		if rabbitConn != nil {
-			_ = rabbitConn.Close()
-		}
-	}()
+	defer broker.Close()
 
 	InitRaft()
+	outboxRelay = StartOutboxRelay(raftNode)
+	if b, ok := broker.(*mq.AMQPBroker); ok {
+		// Events published while disconnected wait in the outbox.
+		b.OnReconnect(outboxRelay.Notify)
+	}
+	StartTrashPurger(raftNode, cfg.TrashRetention)
+
+	r := newRouter(cfg)
+
+	if cfg.WorkerToken == "" {
+		log.Println("WORKER_TOKEN is not set; /internal/ callbacks are disabled")
+	}
+	if cfg.AdminToken == "" {
+		log.Println("ADMIN_TOKEN is not set; pipelines cannot be changed")
+	}
+
+	log.Printf("Node service listening on :%s", cfg.Port)
+	if err := http.ListenAndServe(":"+cfg.Port, r); err != nil {
+		log.Fatalf("server failed: %v", err)
+	}
+}
 
+// newRouter registers the node's public API and the /internal/ callbacks
+// workers use.
+func newRouter(cfg Config) *mux.Router {
 	r := mux.NewRouter()
 	
 	r.HandleFunc("/upload", UploadHandler(cfg)).Methods("POST")
 	r.HandleFunc("/videos", VideosListHandler).Methods("GET")
+	r.HandleFunc("/videos/{id}", GetVideoHandler).Methods("GET")
+	r.HandleFunc("/videos/{id}", UpdateVideoHandler).Methods("PUT", "PATCH")
+	r.HandleFunc("/videos/{id}", DeleteVideoHandler).Methods("DELETE")
+	r.HandleFunc("/videos/{id}/stream", StreamVideoHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/thumbnail", ThumbnailHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/thumbnail", SetThumbnailHandler).Methods("PUT")
+	r.HandleFunc("/videos/{id}/storyboard.vtt", StoryboardVTTHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/storyboard/{name}", StoryboardSpriteHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/hls/{path:.+}", HLSHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/dash/manifest.mpd", DASHManifestHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/videos/{id}/restore", RestoreVideoHandler).Methods("POST")
+	r.HandleFunc("/videos/{id}/jobs", VideoJobsHandler).Methods("GET")
+	r.HandleFunc("/videos/{id}/reprocess", ReprocessHandler).Methods("POST")
+	r.HandleFunc("/videos/{id}/cancel", CancelJobsHandler).Methods("POST")
+	r.HandleFunc("/trash", TrashListHandler).Methods("GET")
+	
+	r.HandleFunc("/pipelines", PipelinesListHandler(cfg)).Methods("GET")
+	r.HandleFunc("/pipelines/{name}", GetPipelineHandler(cfg)).Methods("GET")
+	admin := RequireAdminToken(cfg.AdminToken)
+	r.Handle("/pipelines/{name}", admin(http.HandlerFunc(PutPipelineHandler))).Methods("PUT")
+	r.Handle("/pipelines/{name}", admin(http.HandlerFunc(DeletePipelineHandler))).Methods("DELETE")
 	
 	r.HandleFunc("/raft/status", RaftStatusHandler).Methods("GET")
 	
-	r.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	internal := r.PathPrefix("/internal").Subrouter()
+	internal.Use(RequireWorkerToken(cfg.WorkerToken))
+	internal.HandleFunc("/videos/{id}/renditions", RenditionsCallbackHandler).Methods("POST")
+	internal.HandleFunc("/videos/{id}/probe", ProbeCallbackHandler).Methods("POST")
+	internal.HandleFunc("/videos/{id}/thumbnails", ThumbnailsCallbackHandler).Methods("POST")
+	internal.HandleFunc("/videos/{id}/jobs/{type}", JobStatusCallbackHandler).Methods("POST")
+	
+	r.HandleFunc("/healthz", HealthHandler).Methods("GET")
 
-	log.Printf("Node service listening on :%s", cfg.Port)
-	if err := http.ListenAndServe(":"+cfg.Port, r); err != nil {
-		log.Fatalf("server failed: %v", err)
-	}
+	return r
 }
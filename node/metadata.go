@@ -1,8 +1,147 @@
 package main
 
+import (
+	"fmt"
+	"strings"
+)
+
 type VideoMeta struct {
 	Bucket      string `json:"bucket"`
 	Object      string `json:"object"`
 	Size        int64  `json:"size"`
 	ContentType string `json:"content_type"`
 }
+
+// ProbeInfo is the media information extracted from the original upload.
+type ProbeInfo struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	BitRate         int64   `json:"bit_rate"`
+	Container       string  `json:"container"`
+	VideoCodec      string  `json:"video_codec,omitempty"`
+	AudioCodec      string  `json:"audio_codec,omitempty"`
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	FrameRate       float64 `json:"frame_rate,omitempty"`
+	Rotation        int     `json:"rotation,omitempty"`
+}
+
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// viewable reports whether meta may be served to viewers. There are no
+// accounts to grant access to a private video, so it is withheld from every
+// viewing endpoint; it can still be edited, e.g. to publish it.
+func viewable(meta VideoMetadata) bool {
+	return meta.DeletedAt == nil && meta.Visibility != VisibilityPrivate
+}
+
+// listed reports whether meta appears in GET /videos. Unlisted videos are
+// only reachable by id.
+func listed(meta VideoMetadata) bool {
+	return viewable(meta) && meta.Visibility != VisibilityUnlisted
+}
+
+const (
+	maxTitleLen       = 200
+	maxDescriptionLen = 5000
+	maxTags           = 32
+	maxTagLen         = 64
+)
+
+// VideoPatch holds the user-editable fields of a video. Nil fields are left
+// unchanged.
+type VideoPatch struct {
+	Title       *string   `json:"title,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Tags        *[]string `json:"tags,omitempty"`
+	Visibility  *string   `json:"visibility,omitempty"`
+}
+
+func (p *VideoPatch) Validate() error {
+	if p.Title != nil {
+		t := strings.TrimSpace(*p.Title)
+		if t == "" {
+			return fmt.Errorf("title must not be empty")
+		}
+		if len(t) > maxTitleLen {
+			return fmt.Errorf("title must be at most %d characters", maxTitleLen)
+		}
+		p.Title = &t
+	}
+	if p.Description != nil && len(*p.Description) > maxDescriptionLen {
+		return fmt.Errorf("description must be at most %d characters", maxDescriptionLen)
+	}
+	if p.Tags != nil {
+		tags := normalizeTags(*p.Tags)
+		if len(tags) > maxTags {
+			return fmt.Errorf("at most %d tags are allowed", maxTags)
+		}
+		for _, tag := range tags {
+			if len(tag) > maxTagLen {
+				return fmt.Errorf("tag %q must be at most %d characters", tag, maxTagLen)
+			}
+		}
+		p.Tags = &tags
+	}
+	if p.Visibility != nil {
+		switch *p.Visibility {
+		case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		default:
+			return fmt.Errorf("visibility must be one of %s, %s, %s",
+				VisibilityPublic, VisibilityUnlisted, VisibilityPrivate)
+		}
+	}
+	return nil
+}
+
+func (p *VideoPatch) applyTo(meta *VideoMetadata) {
+	if p.Title != nil {
+		meta.Title = *p.Title
+	}
+	if p.Description != nil {
+		meta.Description = *p.Description
+	}
+	if p.Tags != nil {
+		meta.Tags = append([]string{}, (*p.Tags)...)
+	}
+	if p.Visibility != nil {
+		meta.Visibility = *p.Visibility
+	}
+}
+
+// fields lists the JSON names of the fields the patch sets.
+func (p *VideoPatch) fields() []string {
+	var fields []string
+	if p.Title != nil {
+		fields = append(fields, "title")
+	}
+	if p.Description != nil {
+		fields = append(fields, "description")
+	}
+	if p.Tags != nil {
+		fields = append(fields, "tags")
+	}
+	if p.Visibility != nil {
+		fields = append(fields, "visibility")
+	}
+	return fields
+}
+
+// normalizeTags lowercases, trims and de-duplicates tags, keeping the first
+// occurrence order.
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"events"
+	"platform/mq"
+
+	"github.com/google/uuid"
+)
+
+const (
+	outboxPollInterval = time.Second
+	outboxMinBackoff   = time.Second
+	outboxMaxBackoff   = time.Minute
+	// outboxUnroutableTimeout is how long a job request may find no queue
+	// bound for it, e.g. while workers start, before its job is failed.
+	outboxUnroutableTimeout = 10 * time.Minute
+)
+
+// OutboxEvent is a broker message that has been committed to the replicated
+// state but not yet acknowledged as published.
+type OutboxEvent struct {
+	ID string `json:"id"`
+	// RoutingKey is the event type. Events committed before topic routing
+	// hold a queue name here instead; see events.RoutingKey.
+	RoutingKey string          `json:"queue"`
+	Body       json.RawMessage `json:"body"`
+	CreatedAt  time.Time       `json:"created_at"`
+	// Required events are job requests: when no queue has been bound for
+	// one for too long, its job is failed instead of waiting any longer.
+	Required bool `json:"required,omitempty"`
+}
+
+// NewOutboxEvent wraps data in an event envelope routed by its type. The
+// outbox ID doubles as the envelope and broker message ID.
+func NewOutboxEvent(eventType string, version int, videoID, traceParent string, data interface{}) (OutboxEvent, error) {
+	return newOutboxEvent(uuid.NewString(), time.Now(), eventType, version, videoID, traceParent, data)
+}
+
+// newOutboxEvent lets apply functions pass a deterministic id and time.
+func newOutboxEvent(id string, at time.Time, eventType string, version int, videoID, traceParent string, data interface{}) (OutboxEvent, error) {
+	env, err := events.New(id, eventType, version, videoID, traceParent, data)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	env.Time = at.UTC()
+	body, err := json.Marshal(env)
+	if err != nil {
+		return OutboxEvent{}, fmt.Errorf("encode %s event: %w", eventType, err)
+	}
+	return OutboxEvent{
+		ID:         id,
+		RoutingKey: eventType,
+		Body:       body,
+		CreatedAt:  at,
+	}, nil
+}
+
+// raiseEvent enqueues an event produced by applying cmd. It must be called
+// with r.mu held.
+func (r *RaftNode) raiseEvent(cmd Command, eventType string, version int, videoID string, data interface{}) {
+	ev, err := eventFromCommand(cmd, eventType, eventType, version, videoID, data)
+	if err != nil {
+		log.Printf("outbox: dropping %s event for %s: %v", eventType, videoID, err)
+		return
+	}
+	r.outbox[ev.ID] = ev
+}
+
+// raiseRoutedEvent is raiseEvent for an event routed by key rather than its
+// type, such as a job request for one worker.
+func (r *RaftNode) raiseRoutedEvent(cmd Command, key, eventType string, version int, videoID string, required bool, data interface{}) {
+	ev, err := eventFromCommand(cmd, key, eventType, version, videoID, data)
+	if err != nil {
+		log.Printf("outbox: dropping %s event for %s: %v", key, videoID, err)
+		return
+	}
+	ev.Required = required
+	r.outbox[ev.ID] = ev
+}
+
+// eventFromCommand derives the event's ID and time from cmd, so every
+// replica applying it commits the same event.
+func eventFromCommand(cmd Command, key, eventType string, version int, videoID string, data interface{}) (OutboxEvent, error) {
+	name := fmt.Sprintf("%s/%s/%s", key, videoID, cmd.Timestamp.UTC().Format(time.RFC3339Nano))
+	id := uuid.NewSHA1(uuid.NameSpaceURL, []byte(name)).String()
+	ev, err := newOutboxEvent(id, cmd.Timestamp, eventType, version, videoID, cmd.TraceParent, data)
+	ev.RoutingKey = key
+	return ev, err
+}
+
+// enqueueEvents must be called with r.mu held.
+func (r *RaftNode) enqueueEvents(events []OutboxEvent) {
+	for _, ev := range events {
+		r.outbox[ev.ID] = ev
+	}
+}
+
+// dropVideoEvents removes a purged video's undelivered events, except the
+// deletion and cancellations workers still need to stop its work. It must be
+// called with r.mu held.
+func (r *RaftNode) dropVideoEvents(videoID string) {
+	for id, ev := range r.outbox {
+		env, err := events.Decode(ev.Body)
+		if err != nil || env.VideoID != videoID {
+			continue
+		}
+		if env.Type != events.TypeVideoDeleted && env.Type != events.TypeJobCanceled {
+			delete(r.outbox, id)
+		}
+	}
+}
+
+func (r *RaftNode) applyAckOutbox(cmd Command) (ApplyResult, error) {
+	for _, id := range cmd.EventIDs {
+		delete(r.outbox, id)
+	}
+	return ApplyResult{}, nil
+}
+
+// PendingEvents returns undelivered outbox events, oldest first.
+func (r *RaftNode) PendingEvents() []OutboxEvent {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := make([]OutboxEvent, 0, len(r.outbox))
+	for _, ev := range r.outbox {
+		events = append(events, ev)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+	return events
+}
+
+// OutboxRelay publishes committed outbox events from the leader. Delivery is
+// at-least-once: an event is only removed from the outbox after the broker
+// accepted it, so a crash between publish and ack causes a redelivery.
+type OutboxRelay struct {
+	node              *RaftNode
+	wake              chan struct{}
+	stop, stopped     chan struct{}
+	unroutableTimeout time.Duration
+
+	mu       sync.Mutex
+	attempts map[string]int
+	nextTry  map[string]time.Time
+	// unroutableSince is when a required event was first returned as
+	// unroutable by this leader.
+	unroutableSince map[string]time.Time
+}
+
+var outboxRelay *OutboxRelay
+
+func StartOutboxRelay(node *RaftNode) *OutboxRelay {
+	relay := newOutboxRelay(node, outboxUnroutableTimeout)
+	go relay.run()
+	return relay
+}
+
+func newOutboxRelay(node *RaftNode, unroutableTimeout time.Duration) *OutboxRelay {
+	return &OutboxRelay{
+		node:              node,
+		wake:              make(chan struct{}, 1),
+		stop:              make(chan struct{}),
+		stopped:           make(chan struct{}),
+		unroutableTimeout: unroutableTimeout,
+		attempts:          make(map[string]int),
+		nextTry:           make(map[string]time.Time),
+		unroutableSince:   make(map[string]time.Time),
+	}
+}
+
+// Notify asks the relay to flush now instead of waiting for the next poll.
+func (o *OutboxRelay) Notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop ends the relay once a flush in progress is done; events left in the
+// outbox stay there.
+func (o *OutboxRelay) Stop() {
+	close(o.stop)
+	<-o.stopped
+}
+
+func (o *OutboxRelay) run() {
+	defer close(o.stopped)
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-o.wake:
+		case <-o.stop:
+			return
+		}
+		if o.node.IsLeader() {
+			o.flush()
+		}
+	}
+}
+
+func (o *OutboxRelay) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	pending := o.node.PendingEvents()
+	live := make(map[string]bool, len(pending))
+	var delivered []string
+
+	for _, ev := range pending {
+		live[ev.ID] = true
+		if time.Now().Before(o.nextTry[ev.ID]) {
+			continue
+		}
+
+		key := events.RoutingKey(ev.RoutingKey)
+		err := broker.Publish(context.Background(), key, mq.Message{
+			ID:          ev.ID,
+			ContentType: events.ContentType,
+			Timestamp:   ev.CreatedAt,
+			Body:        ev.Body,
+		})
+		// Events nobody subscribes to yet are retried with backoff like
+		// any other failure, in case a consumer binds later.
+		if errors.Is(err, mq.ErrUnroutable) && ev.Required && o.expireUnroutable(ev) {
+			delivered = append(delivered, ev.ID)
+			continue
+		}
+		if err != nil {
+			o.attempts[ev.ID]++
+			backoff := outboxBackoff(o.attempts[ev.ID])
+			o.nextTry[ev.ID] = time.Now().Add(backoff)
+			log.Printf("outbox: publish %s to %s failed (attempt %d), retrying in %s: %v",
+				ev.ID, key, o.attempts[ev.ID], backoff, err)
+			continue
+		}
+		delivered = append(delivered, ev.ID)
+	}
+
+	if len(delivered) > 0 {
+		if _, err := o.node.Propose(Command{Type: CmdAckOutbox, EventIDs: delivered}); err != nil {
+			log.Printf("outbox: failed to ack %d delivered events: %v", len(delivered), err)
+		}
+	}
+
+	for id := range o.attempts {
+		if !live[id] {
+			delete(o.attempts, id)
+			delete(o.nextTry, id)
+			delete(o.unroutableSince, id)
+		}
+	}
+	for _, id := range delivered {
+		delete(o.attempts, id)
+		delete(o.nextTry, id)
+		delete(o.unroutableSince, id)
+	}
+}
+
+// expireUnroutable fails the job a required job request is for once no
+// worker has been bound to receive it for unroutableTimeout, and reports
+// whether the request can be dropped.
+func (o *OutboxRelay) expireUnroutable(ev OutboxEvent) bool {
+	since, ok := o.unroutableSince[ev.ID]
+	if !ok {
+		since = time.Now()
+		o.unroutableSince[ev.ID] = since
+	}
+	if time.Since(since) < o.unroutableTimeout {
+		return false
+	}
+
+	env, req, err := events.DecodeJobRequested(ev.Body)
+	if err != nil {
+		return false
+	}
+	_, err = o.node.Propose(Command{
+		Type:    CmdSetJobStatus,
+		VideoID: req.VideoID,
+		JobType: req.Job,
+		Job: &JobState{
+			Status: JobFailed,
+			Run:    req.Run,
+			Error:  fmt.Sprintf("no worker consumes %s jobs", req.Job),
+		},
+		TraceParent: events.ChildTraceParent(env.TraceParent),
+	})
+	switch {
+	case err == nil:
+		log.Printf("outbox: no queue bound for %s since %s, failed %s job of video %s",
+			ev.RoutingKey, since.Format(time.RFC3339), req.Job, req.VideoID)
+		return true
+	case errors.Is(err, ErrStaleJobRun), errors.Is(err, ErrVideoNotFound), errors.Is(err, ErrUnknownJob):
+		// The request is obsolete anyway.
+		return true
+	default:
+		log.Printf("outbox: failed to expire %s: %v", ev.ID, err)
+		return false
+	}
+}
+
+func outboxBackoff(attempt int) time.Duration {
+	d := outboxMinBackoff
+	for i := 1; i < attempt && d < outboxMaxBackoff; i++ {
+		d *= 2
+	}
+	if d > outboxMaxBackoff {
+		d = outboxMaxBackoff
+	}
+	return d
+}
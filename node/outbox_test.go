@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"events"
+	"platform/mq"
+)
+
+func TestUnroutableJobRequestExpires(t *testing.T) {
+	broker = mq.NewMemoryBroker()
+	defer broker.Close()
+	r := newTestRaft()
+	video := testVideo("v1")
+	p := Pipeline{Name: "test", Steps: []PipelineStep{
+		{Name: "probe"},
+		{Name: "transcode", DependsOn: []string{"probe"}},
+	}}
+	video.Pipeline = &p
+	video.Jobs = NewJobStates(p.stepNames(), time.Now())
+	if _, err := r.Propose(Command{Type: CmdStoreVideo, Video: video}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Within the timeout the request waits for a worker.
+	newOutboxRelay(r, time.Hour).flush()
+	meta, _ := r.GetVideoMetadata("v1")
+	if meta.Jobs["probe"].Status != JobQueued || len(r.PendingEvents()) != 1 {
+		t.Fatalf("probe = %+v with %d pending events, want it still queued", meta.Jobs["probe"], len(r.PendingEvents()))
+	}
+
+	relay := newOutboxRelay(r, 0)
+	relay.flush()
+	meta, _ = r.GetVideoMetadata("v1")
+	if probe := meta.Jobs["probe"]; probe.Status != JobFailed || probe.Error != "no worker consumes probe jobs" {
+		t.Errorf("probe = %+v, want failed for lack of a worker", probe)
+	}
+	if transcode := meta.Jobs["transcode"]; transcode.Status != JobSkipped {
+		t.Errorf("transcode = %+v, want skipped", transcode)
+	}
+	if meta.Status != StatusFailed {
+		t.Errorf("video status = %s, want %s", meta.Status, StatusFailed)
+	}
+
+	// What is left is the video.failed event, which waits for a consumer.
+	relay.flush()
+	if pending := r.PendingEvents(); len(pending) != 1 || pending[0].RoutingKey != events.TypeVideoFailed {
+		t.Errorf("pending events = %+v, want only %s", pending, events.TypeVideoFailed)
+	}
+}
+
+func TestUnroutableEventWaitsForConsumer(t *testing.T) {
+	b := mq.NewMemoryBroker()
+	broker = b
+	defer b.Close()
+	r := newTestRaft()
+	if _, err := r.Propose(Command{Type: CmdStoreVideo, Video: testVideo("v1")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Propose(Command{Type: CmdDeleteVideo, VideoID: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	var canceled OutboxEvent
+	for _, ev := range r.PendingEvents() {
+		if ev.RoutingKey == "job.canceled.probe" {
+			canceled = ev
+		}
+	}
+	if canceled.ID == "" {
+		t.Fatalf("no job.canceled.probe event in %+v", r.PendingEvents())
+	}
+
+	relay := newOutboxRelay(r, time.Hour)
+	relay.flush()
+	if !pending(r, canceled.ID) {
+		t.Fatal("unroutable event was dropped")
+	}
+	if relay.attempts[canceled.ID] != 1 || !relay.nextTry[canceled.ID].After(time.Now()) {
+		t.Errorf("attempts = %d, next try %s, want a backoff", relay.attempts[canceled.ID], relay.nextTry[canceled.ID])
+	}
+
+	// Once a queue is bound the retry delivers it.
+	if err := b.Bind("cancel.probe", "job.canceled.probe"); err != nil {
+		t.Fatal(err)
+	}
+	relay.nextTry[canceled.ID] = time.Time{}
+	relay.flush()
+	if pending(r, canceled.ID) {
+		t.Error("event still pending after a queue was bound")
+	}
+}
+
+func pending(r *RaftNode, id string) bool {
+	for _, ev := range r.PendingEvents() {
+		if ev.ID == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"events"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultPipeline is used for uploads that do not name one. Unless it has
+// been defined explicitly it runs every PROCESSING_JOBS job at once.
+const DefaultPipeline = "default"
+
+const maxPipelineSteps = 32
+
+var ErrPipelineNotFound = errors.New("pipeline not found")
+
+// Names end up in routing keys and queue names, so no dots.
+var pipelineNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Pipeline is a named DAG of processing steps. Each step is a job type run by
+// a worker, and is dispatched once every step it depends on is ready.
+type Pipeline struct {
+	Name  string         `json:"name"`
+	Steps []PipelineStep `json:"steps"`
+}
+
+type PipelineStep struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+func defaultPipeline(jobs []string) Pipeline {
+	p := Pipeline{Name: DefaultPipeline}
+	for _, job := range jobs {
+		p.Steps = append(p.Steps, PipelineStep{Name: job})
+	}
+	return p
+}
+
+// Validate checks names and that the steps form a DAG.
+func (p Pipeline) Validate() error {
+	if !pipelineNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("pipeline name %q must be lowercase letters, digits, '-' or '_'", p.Name)
+	}
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("pipeline %s has no steps", p.Name)
+	}
+	if len(p.Steps) > maxPipelineSteps {
+		return fmt.Errorf("pipeline %s has more than %d steps", p.Name, maxPipelineSteps)
+	}
+
+	deps := make(map[string][]string, len(p.Steps))
+	for _, step := range p.Steps {
+		if !pipelineNamePattern.MatchString(step.Name) {
+			return fmt.Errorf("step name %q must be lowercase letters, digits, '-' or '_'", step.Name)
+		}
+		if _, dup := deps[step.Name]; dup {
+			return fmt.Errorf("step %s is defined twice", step.Name)
+		}
+		deps[step.Name] = step.DependsOn
+	}
+	for _, step := range p.Steps {
+		for _, dep := range step.DependsOn {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("step %s depends on unknown step %s", step.Name, dep)
+			}
+		}
+	}
+
+	// Repeatedly remove steps whose dependencies have all been removed; any
+	// left over are on a cycle.
+	done := make(map[string]bool, len(deps))
+	for progress := true; progress; {
+		progress = false
+		for name, stepDeps := range deps {
+			if done[name] || !allDone(stepDeps, done) {
+				continue
+			}
+			done[name] = true
+			progress = true
+		}
+	}
+	if len(done) < len(deps) {
+		var cyclic []string
+		for name := range deps {
+			if !done[name] {
+				cyclic = append(cyclic, name)
+			}
+		}
+		sort.Strings(cyclic)
+		return fmt.Errorf("steps %v form a dependency cycle", cyclic)
+	}
+	return nil
+}
+
+func allDone(names []string, done map[string]bool) bool {
+	for _, name := range names {
+		if !done[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// videoPipeline returns the pipeline meta is processed with. Videos uploaded
+// before pipelines existed get one running their jobs independently.
+func videoPipeline(meta VideoMetadata) Pipeline {
+	if meta.Pipeline != nil {
+		return *meta.Pipeline
+	}
+	names := make([]string, 0, len(meta.Jobs))
+	for name := range meta.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return defaultPipeline(names)
+}
+
+// withDependents returns names plus every step that transitively depends on
+// one of them, in pipeline order.
+func (p Pipeline) withDependents(names []string) []string {
+	selected := make(map[string]bool, len(p.Steps))
+	for _, name := range names {
+		selected[name] = true
+	}
+	// Steps may be listed before their dependencies, so repeat until no
+	// more are added.
+	for added := true; added; {
+		added = false
+		for _, step := range p.Steps {
+			if selected[step.Name] {
+				continue
+			}
+			for _, dep := range step.DependsOn {
+				if selected[dep] {
+					selected[step.Name] = true
+					added = true
+					break
+				}
+			}
+		}
+	}
+
+	var out []string
+	for _, step := range p.Steps {
+		if selected[step.Name] {
+			out = append(out, step.Name)
+		}
+	}
+	return out
+}
+
+func (p Pipeline) stepNames() []string {
+	names := make([]string, len(p.Steps))
+	for i, step := range p.Steps {
+		names[i] = step.Name
+	}
+	return names
+}
+
+// advancePipeline skips the pending steps of meta that can no longer run and
+// queues every pending or skipped step whose dependencies are ready, raising
+// a job.requested event for each. It runs while applying a command, so
+// dispatch happens exactly once per step even across leader changes.
+// meta.Jobs must not be shared with the stored video. Must be called with
+// r.mu held.
+func (r *RaftNode) advancePipeline(cmd Command, meta *VideoMetadata) {
+	if meta.DeletedAt != nil {
+		return
+	}
+
+	pipeline := videoPipeline(*meta)
+	skipBlockedSteps(cmd, meta, pipeline)
+	for _, step := range pipeline.Steps {
+		job, ok := meta.Jobs[step.Name]
+		if !ok || (job.Status != JobPending && job.Status != JobSkipped) {
+			continue
+		}
+		ready := true
+		for _, dep := range step.DependsOn {
+			if meta.Jobs[dep].Status != JobReady {
+				ready = false
+				break
+			}
+		}
+		if !ready {
+			continue
+		}
+
+		job.Status = JobQueued
+		job.Run++
+		job.UpdatedAt = cmd.Timestamp
+		meta.Jobs[step.Name] = job
+		key := events.JobRoutingKey(events.TypeJobRequested, step.Name)
+		r.raiseRoutedEvent(cmd, key, events.TypeJobRequested, events.JobRequestedVersion, meta.ID, true, events.JobRequested{
+			VideoID:     meta.ID,
+			Job:         step.Name,
+			Run:         job.Run,
+			Pipeline:    pipeline.Name,
+			Bucket:      meta.Bucket,
+			Object:      meta.Object,
+			Size:        meta.Size,
+			ContentType: meta.ContentType,
+			Params:      jobParams(*meta, step.Name),
+		})
+	}
+}
+
+// skipBlockedSteps marks pending steps skipped once a step they depend on has
+// failed or was skipped itself, so they do not wait forever. A skipped step
+// runs after all if its dependencies become ready, e.g. when a failed job is
+// retried and succeeds.
+func skipBlockedSteps(cmd Command, meta *VideoMetadata, pipeline Pipeline) {
+	for changed := true; changed; {
+		changed = false
+		for _, step := range pipeline.Steps {
+			job, ok := meta.Jobs[step.Name]
+			if !ok || job.Status != JobPending {
+				continue
+			}
+			for _, dep := range step.DependsOn {
+				if status := meta.Jobs[dep].Status; status == JobFailed || status == JobSkipped {
+					job.Status = JobSkipped
+					job.Error = fmt.Sprintf("%s %s", dep, status)
+					job.UpdatedAt = cmd.Timestamp
+					meta.Jobs[step.Name] = job
+					changed = true
+					break
+				}
+			}
+		}
+	}
+}
+
+func (r *RaftNode) applyPutPipeline(cmd Command) (ApplyResult, error) {
+	if cmd.Pipeline == nil {
+		return ApplyResult{}, fmt.Errorf("put_pipeline: missing pipeline")
+	}
+	r.pipelines[cmd.Pipeline.Name] = *cmd.Pipeline
+	return ApplyResult{}, nil
+}
+
+func (r *RaftNode) applyDeletePipeline(cmd Command) (ApplyResult, error) {
+	if _, ok := r.pipelines[cmd.PipelineName]; !ok {
+		return ApplyResult{}, ErrPipelineNotFound
+	}
+	delete(r.pipelines, cmd.PipelineName)
+	return ApplyResult{}, nil
+}
+
+// ResolvePipeline returns the named pipeline, or the default one for an
+// empty name. Videos keep a copy, so later edits only affect new uploads.
+func (r *RaftNode) ResolvePipeline(name string, defaultJobs []string) (Pipeline, error) {
+	if name == "" {
+		name = DefaultPipeline
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if p, ok := r.pipelines[name]; ok {
+		return p, nil
+	}
+	if name == DefaultPipeline {
+		return defaultPipeline(defaultJobs), nil
+	}
+	return Pipeline{}, ErrPipelineNotFound
+}
+
+// ListPipelines returns the defined pipelines plus the built-in default if it
+// has not been overridden, sorted by name.
+func (r *RaftNode) ListPipelines(defaultJobs []string) []Pipeline {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pipelines := make([]Pipeline, 0, len(r.pipelines)+1)
+	for _, p := range r.pipelines {
+		pipelines = append(pipelines, p)
+	}
+	if _, ok := r.pipelines[DefaultPipeline]; !ok {
+		pipelines = append(pipelines, defaultPipeline(defaultJobs))
+	}
+	sort.Slice(pipelines, func(i, j int) bool {
+		return pipelines[i].Name < pipelines[j].Name
+	})
+	return pipelines
+}
+
+func PipelinesListHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(raftNode.ListPipelines(cfg.ProcessingJobs))
+	}
+}
+
+func GetPipelineHandler(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := raftNode.ResolvePipeline(mux.Vars(r)["name"], cfg.ProcessingJobs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(p)
+	}
+}
+
+// PutPipelineHandler creates or replaces a pipeline. Videos already being
+// processed keep the definition they started with.
+func PutPipelineHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+
+	var p Pipeline
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.Name = mux.Vars(r)["name"]
+	if err := p.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if _, err := raftNode.Propose(Command{Type: CmdPutPipeline, Pipeline: &p}); err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// DeletePipelineHandler removes a pipeline. Deleting "default" restores the
+// built-in one.
+func DeletePipelineHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+
+	_, err := raftNode.Propose(Command{Type: CmdDeletePipeline, PipelineName: mux.Vars(r)["name"]})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"platform/worker"
+)
+
+// TestPipelineEndToEnd runs the node and a worker in one process over the
+// in-memory broker: an upload is dispatched through the outbox, the first
+// step fails once and is retried, its dependent runs once it is ready, and
+// the video ends up ready with nothing left in the outbox.
+func TestPipelineEndToEnd(t *testing.T) {
+	srv := startTestNode(t, Config{MinIOBucket: "videos", ProcessingJobs: []string{"probe"}, WorkerToken: "secret", AdminToken: "admin"})
+	// Stand in for the consumers of the video events, which the outbox
+	// otherwise keeps retrying.
+	if err := broker.Bind("test.events", "video.#"); err != nil {
+		t.Fatal(err)
+	}
+
+	worker.Init(worker.Config{Broker: broker, NodeAPIURL: srv.URL, WorkerToken: "secret", Bucket: "videos"})
+	retry := worker.RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	var probeAttempts atomic.Int32
+	err := worker.RegisterJob(worker.JobSpec{
+		Type:  "probe",
+		Queue: "test.jobs.probe",
+		Retry: retry,
+		Handle: func(ctx context.Context, job worker.Job) (worker.JobResult, error) {
+			if probeAttempts.Add(1) == 1 {
+				return worker.JobResult{}, errors.New("transient failure")
+			}
+			obj, _, err := objectStore.Get(ctx, job.Bucket, job.Object)
+			if err != nil {
+				return worker.JobResult{}, err
+			}
+			defer obj.Close()
+			n, err := io.Copy(io.Discard, obj)
+			return worker.JobResult{Details: map[string]interface{}{"bytes": n}}, err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = worker.RegisterJob(worker.JobSpec{
+		Type:  "thumbnail",
+		Queue: "test.jobs.thumbnail",
+		Retry: retry,
+		Handle: func(ctx context.Context, job worker.Job) (worker.JobResult, error) {
+			key := DerivedPrefix(job.VideoID) + "thumbnails/default.jpg"
+			_, err := objectStore.Put(ctx, job.Bucket, key, strings.NewReader("jpeg"), 4, "image/jpeg")
+			return worker.JobResult{Outputs: []string{key}}, err
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := worker.StartJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pipeline := `{"steps":[{"name":"probe"},{"name":"thumbnail","depends_on":["probe"]}]}`
+	for auth, want := range map[string]int{"": http.StatusUnauthorized, "Bearer secret": http.StatusUnauthorized, "Bearer admin": http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/pipelines/test", strings.NewReader(pipeline))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("put pipeline with Authorization %q: %s, want %d", auth, resp.Status, want)
+		}
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "clip.mp4")
+	fw.Write([]byte("not really a video"))
+	mw.Close()
+	resp, err := http.Post(srv.URL+"/upload?pipeline=test", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploaded VideoMetadata
+	err = json.NewDecoder(resp.Body).Decode(&uploaded)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload: %s %v", resp.Status, err)
+	}
+
+	var meta VideoMetadata
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		meta, err = raftNode.GetVideoMetadata(uploaded.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (meta.Status == StatusReady || meta.Status == StatusFailed) && raftNode.GetStatus().PendingEvents == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("video still %s with %d pending events: %+v", meta.Status, raftNode.GetStatus().PendingEvents, meta.Jobs)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if meta.Status != StatusReady {
+		t.Fatalf("status = %s, want %s: %+v", meta.Status, StatusReady, meta.Jobs)
+	}
+	probe := meta.Jobs["probe"]
+	if probe.Status != JobReady || probe.Run != 1 || probe.Attempts != 2 {
+		t.Errorf("probe job = %+v, want ready on run 1 after 2 attempts", probe)
+	}
+	if thumb := meta.Jobs["thumbnail"]; thumb.Status != JobReady || thumb.Attempts != 1 {
+		t.Errorf("thumbnail job = %+v, want ready after 1 attempt", thumb)
+	}
+	if _, err := objectStore.Stat(context.Background(), "videos", DerivedPrefix(meta.ID)+"thumbnails/default.jpg"); err != nil {
+		t.Errorf("thumbnail not written: %v", err)
+	}
+}
+
+func TestFailedStepSkipsDependents(t *testing.T) {
+	r := newTestRaft()
+	video := testVideo("v1")
+	p := Pipeline{Name: "test", Steps: []PipelineStep{
+		{Name: "probe"},
+		{Name: "transcode", DependsOn: []string{"probe"}},
+		{Name: "captions", DependsOn: []string{"transcode"}},
+		{Name: "thumbnail"},
+	}}
+	video.Pipeline = &p
+	video.Jobs = NewJobStates(p.stepNames(), time.Now())
+	if _, err := r.Propose(Command{Type: CmdStoreVideo, Video: video}); err != nil {
+		t.Fatal(err)
+	}
+
+	report := func(jobType, status string) VideoMetadata {
+		t.Helper()
+		res, err := r.Propose(Command{Type: CmdSetJobStatus, VideoID: "v1", JobType: jobType, Job: &JobState{Status: status, Run: 1}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Video
+	}
+	expect := func(meta VideoMetadata, want map[string]string) {
+		t.Helper()
+		for jobType, status := range want {
+			if got := meta.Jobs[jobType].Status; got != status {
+				t.Errorf("%s = %s, want %s", jobType, got, status)
+			}
+		}
+	}
+
+	meta := report("probe", JobFailed)
+	expect(meta, map[string]string{"probe": JobFailed, "transcode": JobSkipped, "captions": JobSkipped, "thumbnail": JobQueued})
+	if meta.Jobs["captions"].Error != "transcode skipped" || meta.Status != StatusFailed {
+		t.Errorf("captions error %q, video %s", meta.Jobs["captions"].Error, meta.Status)
+	}
+
+	// A retried probe that succeeds lets the skipped steps run after all.
+	report("probe", JobProcessing)
+	meta = report("probe", JobReady)
+	expect(meta, map[string]string{"transcode": JobQueued, "captions": JobSkipped})
+	meta = report("transcode", JobReady)
+	expect(meta, map[string]string{"captions": JobQueued})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// ProbeCallbackHandler records the media information a worker extracted from
+// the original upload.
+func ProbeCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+
+	var probe ProbeInfo
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&probe); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res, err := raftNode.Propose(Command{
+		Type:    CmdSetProbe,
+		VideoID: mux.Vars(r)["id"],
+		Probe:   &probe,
+	})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res.Video)
+}
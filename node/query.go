@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VideoQuery is the filter and sort order accepted by GET /videos.
+type VideoQuery struct {
+	MinDuration float64
+	MaxDuration float64
+	MinHeight   int
+	MaxHeight   int
+	Sort        string
+	Desc        bool
+}
+
+var videoSortKeys = map[string]func(a, b VideoMetadata) bool{
+	"uploaded_at": func(a, b VideoMetadata) bool { return a.UploadedAt.Before(b.UploadedAt) },
+	"size":        func(a, b VideoMetadata) bool { return a.Size < b.Size },
+	"title":       func(a, b VideoMetadata) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) },
+	"duration":    func(a, b VideoMetadata) bool { return probeDuration(a) < probeDuration(b) },
+	"height":      func(a, b VideoMetadata) bool { return probeHeight(a) < probeHeight(b) },
+}
+
+// ParseVideoQuery reads min_duration, max_duration, min_height, max_height
+// and sort (a key from videoSortKeys, "-" prefixed for descending).
+func ParseVideoQuery(values url.Values) (VideoQuery, error) {
+	var q VideoQuery
+	var err error
+
+	if q.MinDuration, err = parseFloatParam(values, "min_duration"); err != nil {
+		return q, err
+	}
+	if q.MaxDuration, err = parseFloatParam(values, "max_duration"); err != nil {
+		return q, err
+	}
+	if q.MinHeight, err = parseIntParam(values, "min_height"); err != nil {
+		return q, err
+	}
+	if q.MaxHeight, err = parseIntParam(values, "max_height"); err != nil {
+		return q, err
+	}
+
+	q.Sort = values.Get("sort")
+	if strings.HasPrefix(q.Sort, "-") {
+		q.Desc = true
+		q.Sort = q.Sort[1:]
+	}
+	if q.Sort != "" {
+		if _, ok := videoSortKeys[q.Sort]; !ok {
+			return q, fmt.Errorf("unknown sort key %q", q.Sort)
+		}
+	}
+	return q, nil
+}
+
+// Apply filters and sorts videos in place. Videos that have not been probed
+// yet are excluded by any duration or height bound.
+func (q VideoQuery) Apply(videos []VideoMetadata) []VideoMetadata {
+	out := videos[:0]
+	for _, v := range videos {
+		if q.MinDuration > 0 && probeDuration(v) < q.MinDuration {
+			continue
+		}
+		if q.MaxDuration > 0 && (v.Probe == nil || probeDuration(v) > q.MaxDuration) {
+			continue
+		}
+		if q.MinHeight > 0 && probeHeight(v) < q.MinHeight {
+			continue
+		}
+		if q.MaxHeight > 0 && (v.Probe == nil || probeHeight(v) > q.MaxHeight) {
+			continue
+		}
+		out = append(out, v)
+	}
+
+	if less, ok := videoSortKeys[q.Sort]; ok {
+		sort.SliceStable(out, func(i, j int) bool {
+			if q.Desc {
+				return less(out[j], out[i])
+			}
+			return less(out[i], out[j])
+		})
+	}
+	return out
+}
+
+func probeDuration(v VideoMetadata) float64 {
+	if v.Probe == nil {
+		return 0
+	}
+	return v.Probe.DurationSeconds
+}
+
+func probeHeight(v VideoMetadata) int {
+	if v.Probe == nil {
+		return 0
+	}
+	return v.Probe.Height
+}
+
+func parseFloatParam(values url.Values, key string) (float64, error) {
+	s := values.Get(key)
+	if s == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative number", key)
+	}
+	return f, nil
+}
+
+func parseIntParam(values url.Values, key string) (int, error) {
+	s := values.Get(key)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", key)
+	}
+	return n, nil
+}
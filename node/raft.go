@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -28,11 +27,18 @@ type RaftNode struct {
 	peers        []string
 	
 	videos       map[string]VideoMetadata
+	idempotencyKeys map[string]idempotencyRecord
+	outbox       map[string]OutboxEvent
+	pipelines    map[string]Pipeline
 }
 
 type VideoMetadata struct {
 	ID          string    `json:"id"`
 	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Tags        []string  `json:"tags"`
+	Visibility  string    `json:"visibility"`
+	Version     int       `json:"version"`
 	Bucket      string    `json:"bucket"`
 	Object      string    `json:"object"`
 	ThumbnailURL string   `json:"thumbnail_url"`
@@ -40,6 +46,16 @@ type VideoMetadata struct {
 	ContentType string    `json:"content_type"`
 	UploadedAt  time.Time `json:"uploaded_at"`
 	Resolutions []string  `json:"resolutions"`
+	StreamingFormats []string `json:"streaming_formats,omitempty"`
+	Probe       *ProbeInfo `json:"probe,omitempty"`
+	// ThumbnailAt is the frame time, in seconds, picked for the thumbnail
+	// by the user; nil lets the worker choose.
+	ThumbnailAt *float64   `json:"thumbnail_at,omitempty"`
+	ThumbnailVariants []ThumbnailVariant `json:"thumbnail_variants,omitempty"`
+	Status      string     `json:"status"`
+	Pipeline    *Pipeline  `json:"pipeline,omitempty"`
+	Jobs        map[string]JobState `json:"jobs,omitempty"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 }
 
 type RaftStatus struct {
@@ -48,6 +64,7 @@ type RaftStatus struct {
 	State    string    `json:"state"`
 	Term     int       `json:"term"`
 	Peers    []string  `json:"peers"`
+	PendingEvents int  `json:"pending_events"`
 }
 
 var raftNode *RaftNode
@@ -70,6 +87,9 @@ func InitRaft() {
 		lastHeartbeat: time.Now(),
 		peers:        peers,
 		videos:       make(map[string]VideoMetadata),
+		idempotencyKeys: make(map[string]idempotencyRecord),
+		outbox:       make(map[string]OutboxEvent),
+		pipelines:    make(map[string]Pipeline),
 	}
 	
 	go raftNode.Run()
@@ -148,19 +168,13 @@ func (r *RaftNode) GetStatus() RaftStatus {
 		State:    stateStr,
 		Term:     r.currentTerm,
 		Peers:    r.peers,
+		PendingEvents: len(r.outbox),
 	}
 }
 
 func (r *RaftNode) StoreVideoMetadata(meta VideoMetadata) error {
-	if !r.IsLeader() {
-		return fmt.Errorf("not the leader")
-	}
-	
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	
-	r.videos[meta.ID] = meta
-	return nil
+	_, err := r.Propose(Command{Type: CmdStoreVideo, Video: &meta})
+	return err
 }
 
 func (r *RaftNode) GetVideoMetadata(id string) (VideoMetadata, error) {
@@ -169,7 +183,7 @@ func (r *RaftNode) GetVideoMetadata(id string) (VideoMetadata, error) {
 	
 	meta, exists := r.videos[id]
 	if !exists {
-		return VideoMetadata{}, fmt.Errorf("video not found")
+		return VideoMetadata{}, ErrVideoNotFound
 	}
 	
 	return meta, nil
@@ -181,12 +195,30 @@ func (r *RaftNode) ListVideos() []VideoMetadata {
 	
 	videos := make([]VideoMetadata, 0, len(r.videos))
 	for _, video := range r.videos {
+		if video.DeletedAt != nil {
+			continue
+		}
 		videos = append(videos, video)
 	}
 	
 	return videos
 }
 
+// ListTrash returns soft-deleted videos that have not been purged yet.
+func (r *RaftNode) ListTrash() []VideoMetadata {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	
+	videos := []VideoMetadata{}
+	for _, video := range r.videos {
+		if video.DeletedAt != nil {
+			videos = append(videos, video)
+		}
+	}
+	
+	return videos
+}
+
 func RaftStatusHandler(w http.ResponseWriter, r *http.Request) {
 	status := raftNode.GetStatus()
 	w.Header().Set("Content-Type", "application/json")
@@ -194,7 +226,33 @@ func RaftStatusHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func VideosListHandler(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseVideoQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	
 	videos := raftNode.ListVideos()
+	shown := videos[:0]
+	for _, v := range videos {
+		if listed(v) {
+			shown = append(shown, v)
+		}
+	}
+	videos = query.Apply(shown)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(videos)
+}
+
+func TrashListHandler(w http.ResponseWriter, r *http.Request) {
+	videos := raftNode.ListTrash()
+	shown := videos[:0]
+	for _, v := range videos {
+		if v.Visibility != VisibilityPrivate && v.Visibility != VisibilityUnlisted {
+			shown = append(shown, v)
+		}
+	}
+	videos = shown
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(videos)
 }
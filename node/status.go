@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"errors"
+	"events"
+
+	"github.com/gorilla/mux"
+)
+
+// Overall video statuses, derived from the per-job states.
+const (
+	StatusUploaded   = "uploaded"
+	StatusProcessing = "processing"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+	StatusCanceled   = "canceled"
+)
+
+// Per-job states. A pipeline step is pending until the steps it depends on
+// are ready and it is queued for a worker, or skipped if one of them fails.
+// A failed or ready job may go back to processing when it is retried or
+// re-run; a canceled one only when it is reprocessed.
+const (
+	JobPending    = "pending"
+	JobQueued     = "queued"
+	JobProcessing = "processing"
+	JobReady      = "ready"
+	JobFailed     = "failed"
+	JobCanceled   = "canceled"
+	JobSkipped    = "skipped"
+)
+
+// validJobStates are the states a worker may report; pending, canceled and
+// skipped are only set by the node.
+var validJobStates = map[string]bool{
+	JobQueued:     true,
+	JobProcessing: true,
+	JobReady:      true,
+	JobFailed:     true,
+}
+
+// JobState is one job's progress as reported by its worker. Progress is a
+// fraction between 0 and 1; Result is whatever the worker reported the job
+// produced, kept as-is. Run counts how often the job has been dispatched.
+type JobState struct {
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	Run       int             `json:"run"`
+	Attempts  int             `json:"attempts"`
+	Progress  float64         `json:"progress"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// NewJobStates returns the initial state for the given job types, as stored
+// with a freshly uploaded video. Storing it queues the steps that have no
+// dependencies.
+func NewJobStates(jobTypes []string, now time.Time) map[string]JobState {
+	jobs := make(map[string]JobState, len(jobTypes))
+	for _, t := range jobTypes {
+		jobs[t] = JobState{Status: JobPending, UpdatedAt: now}
+	}
+	return jobs
+}
+
+func copyJobs(jobs map[string]JobState) map[string]JobState {
+	out := make(map[string]JobState, len(jobs)+1)
+	for t, job := range jobs {
+		out[t] = job
+	}
+	return out
+}
+
+// deriveStatus folds the job states into the video's overall status.
+func deriveStatus(jobs map[string]JobState) string {
+	if len(jobs) == 0 {
+		return StatusUploaded
+	}
+
+	started, canceled, ready := false, false, 0
+	for _, job := range jobs {
+		switch job.Status {
+		case JobFailed:
+			return StatusFailed
+		case JobCanceled:
+			canceled = true
+		case JobReady:
+			ready++
+			started = true
+		case JobProcessing:
+			started = true
+		}
+	}
+	switch {
+	case canceled:
+		return StatusCanceled
+	case ready == len(jobs):
+		return StatusReady
+	case started:
+		return StatusProcessing
+	default:
+		return StatusUploaded
+	}
+}
+
+func (r *RaftNode) applySetJobStatus(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+	if cmd.Job == nil || cmd.JobType == "" {
+		return ApplyResult{}, fmt.Errorf("set_job_status: missing job")
+	}
+
+	jobs := copyJobs(meta.Jobs)
+
+	current, ok := jobs[cmd.JobType]
+	if !ok {
+		return ApplyResult{Video: meta}, fmt.Errorf("%w: %s", ErrUnknownJob, cmd.JobType)
+	}
+	// Reports for a canceled job or an earlier run come from work that
+	// should have stopped. Workers that predate runs send none.
+	if current.Status == JobCanceled || (cmd.Job.Run != 0 && cmd.Job.Run != current.Run) {
+		return ApplyResult{Video: meta}, ErrStaleJobRun
+	}
+
+	job := *cmd.Job
+	job.UpdatedAt = cmd.Timestamp
+	job.Run = current.Run
+	job.Attempts = current.Attempts
+	// Only picking the job up from the queue is a new attempt; progress
+	// and repeated processing reports are not.
+	if job.Status == JobProcessing && current.Status == JobQueued {
+		job.Attempts++
+	}
+	if job.Status == JobReady {
+		job.Progress = 1
+	}
+	jobs[cmd.JobType] = job
+
+	previous := meta.Status
+	meta.Jobs = jobs
+	r.advancePipeline(cmd, &meta)
+	meta.Status = deriveStatus(jobs)
+	r.videos[meta.ID] = meta
+
+	if meta.Status != previous {
+		switch meta.Status {
+		case StatusReady:
+			r.raiseEvent(cmd, events.TypeVideoProcessed, events.VideoProcessedVersion, meta.ID, events.VideoProcessed{
+				VideoID:          meta.ID,
+				Resolutions:      meta.Resolutions,
+				StreamingFormats: meta.StreamingFormats,
+			})
+		case StatusFailed:
+			r.raiseEvent(cmd, events.TypeVideoFailed, events.VideoFailedVersion, meta.ID, events.VideoFailed{
+				VideoID: meta.ID,
+				Job:     cmd.JobType,
+				Error:   job.Error,
+			})
+		}
+	}
+
+	return ApplyResult{Video: meta}, nil
+}
+
+type jobStatusReport struct {
+	Run      int             `json:"run"`
+	Status   string          `json:"status"`
+	Error    string          `json:"error"`
+	Progress float64         `json:"progress"`
+	Result   json.RawMessage `json:"result"`
+}
+
+// JobStatusCallbackHandler serves POST /internal/videos/{id}/jobs/{type},
+// which workers call as a job starts, finishes or fails.
+func JobStatusCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+
+	var report jobStatusReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&report); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !validJobStates[report.Status] {
+		http.Error(w, fmt.Sprintf("unknown job status %q", report.Status), http.StatusUnprocessableEntity)
+		return
+	}
+	if report.Progress < 0 || report.Progress > 1 {
+		http.Error(w, "progress must be between 0 and 1", http.StatusUnprocessableEntity)
+		return
+	}
+
+	vars := mux.Vars(r)
+	cmd := Command{
+		Type:    CmdSetJobStatus,
+		VideoID: vars["id"],
+		JobType: vars["type"],
+		Job: &JobState{
+			Status:   report.Status,
+			Run:      report.Run,
+			Error:    report.Error,
+			Progress: report.Progress,
+			Result:   report.Result,
+		},
+	}
+	cmd.TraceParent = events.ChildTraceParent(r.Header.Get(events.TraceParentHeader))
+	res, err := raftNode.Propose(cmd)
+	if errors.Is(err, ErrUnknownJob) {
+		// The video has no such job, so the worker should stop working on it.
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	outboxRelay.Notify()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res.Video)
+}
+
+// RequireWorkerToken guards the worker callback API. With no token
+// configured every request is refused.
+func RequireWorkerToken(token string) mux.MiddlewareFunc {
+	return requireBearerToken(token, "WORKER_TOKEN")
+}
+
+// RequireAdminToken guards the routes that change cluster-wide settings,
+// such as pipelines. With no token configured every request is refused.
+func RequireAdminToken(token string) mux.MiddlewareFunc {
+	return requireBearerToken(token, "ADMIN_TOKEN")
+}
+
+// requireBearerToken checks the Authorization header against token, which is
+// configured by the env variable envName.
+func requireBearerToken(token, envName string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.Error(w, "disabled until "+envName+" is set", http.StatusServiceUnavailable)
+				return
+			}
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
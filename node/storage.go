@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"platform/storage"
+)
+
+var objectStore storage.ObjectStore
+
+func InitStorage(cfg Config) error {
+	store, err := storage.Open(storage.Config{
+		Backend:        cfg.StorageBackend,
+		MinIOEndpoint:  cfg.MinIOEndpoint,
+		MinIOAccessKey: cfg.MinIOAccessKey,
+		MinIOSecretKey: cfg.MinIOSecretKey,
+		LocalPath:      cfg.LocalStoragePath,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := store.EnsureBucket(context.Background(), cfg.MinIOBucket); err != nil {
+		return err
+	}
+	objectStore = store
+	return nil
+}
+
+func UploadToStore(bucket string, r *http.Request) (VideoMeta, error) {
+	var meta VideoMeta
+
+	if err := r.ParseMultipartForm(100 << 20); err != nil {
+		return meta, fmt.Errorf("parse multipart form: %w", err)
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return meta, fmt.Errorf("missing form file 'file': %w", err)
+	}
+	defer file.Close()
+
+	objectName := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(header.Filename))
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	info, err := objectStore.Put(context.Background(), bucket, objectName, file, header.Size, contentType)
+	if err != nil {
+		return meta, fmt.Errorf("put object: %w", err)
+	}
+
+	meta = VideoMeta{
+		Bucket:      bucket,
+		Object:      objectName,
+		Size:        info.Size,
+		ContentType: contentType,
+	}
+	return meta, nil
+}
+
+func RemoveFromStore(bucket, object string) error {
+	return objectStore.Delete(context.Background(), bucket, object)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+)
+
+// StoryboardPrefix holds the seek-bar preview sprite sheets worker-thumbnail
+// renders for a video, and the WebVTT track indexing them.
+func StoryboardPrefix(videoID string) string {
+	return DerivedPrefix(videoID) + "storyboard/"
+}
+
+var spriteNamePattern = regexp.MustCompile(`^sprite-[0-9]{3,}\.jpg$`)
+
+// StoryboardVTTHandler serves /videos/{id}/storyboard.vtt, a thumbnail track
+// players load for hover previews. Its cues name sprite sheets relative to
+// it, which StoryboardSpriteHandler serves.
+func StoryboardVTTHandler(w http.ResponseWriter, r *http.Request) {
+	meta, err := raftNode.GetVideoMetadata(mux.Vars(r)["id"])
+	if err != nil || !viewable(meta) {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+
+	// Like thumbnails, storyboards are replaced when a video is reprocessed.
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	serveObject(w, r, meta.Bucket, StoryboardPrefix(meta.ID)+"storyboard.vtt", "text/vtt")
+}
+
+// StoryboardSpriteHandler serves /videos/{id}/storyboard/{name}.
+func StoryboardSpriteHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	meta, err := raftNode.GetVideoMetadata(vars["id"])
+	if err != nil || !viewable(meta) {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+	if !spriteNamePattern.MatchString(vars["name"]) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	serveObject(w, r, meta.Bucket, StoryboardPrefix(meta.ID)+vars["name"], "image/jpeg")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+
+	"platform/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// objectReadSeeker adapts ranged reads from the object store to the
+// io.ReadSeeker that http.ServeContent expects. Seeking is free; the
+// underlying range request is only opened on the next Read.
+type objectReadSeeker struct {
+	ctx    context.Context
+	bucket string
+	key    string
+	size   int64
+
+	offset int64
+	body   io.ReadCloser
+}
+
+func newObjectReadSeeker(ctx context.Context, bucket, key string, size int64) *objectReadSeeker {
+	return &objectReadSeeker{ctx: ctx, bucket: bucket, key: key, size: size}
+}
+
+func (o *objectReadSeeker) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		body, err := objectStore.GetRange(o.ctx, o.bucket, o.key, o.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *objectReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = o.offset + offset
+	case io.SeekEnd:
+		abs = o.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, fmt.Errorf("negative position %d", abs)
+	}
+	if abs != o.offset {
+		o.Close()
+		o.offset = abs
+	}
+	return abs, nil
+}
+
+func (o *objectReadSeeker) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
+
+// serveObject streams bucket/key with Range, If-Range, If-None-Match and
+// If-Modified-Since handled by http.ServeContent.
+func serveObject(w http.ResponseWriter, r *http.Request, bucket, key, fallbackType string) {
+	info, err := objectStore.Stat(r.Context(), bucket, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			http.Error(w, "object not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to read object: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	contentType := info.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = fallbackType
+	}
+	if contentType == "" || contentType == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(path.Ext(key)); byExt != "" {
+			contentType = byExt
+		}
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if info.ETag != "" {
+		w.Header().Set("ETag", `"`+info.ETag+`"`)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+
+	body := newObjectReadSeeker(r.Context(), bucket, key, info.Size)
+	defer body.Close()
+
+	http.ServeContent(w, r, path.Base(key), info.LastModified, body)
+}
+
+func StreamVideoHandler(w http.ResponseWriter, r *http.Request) {
+	meta, err := raftNode.GetVideoMetadata(mux.Vars(r)["id"])
+	if err != nil || !viewable(meta) {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+
+	serveObject(w, r, meta.Bucket, meta.Object, meta.ContentType)
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"events"
+	"platform/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// thumbnailJobType is the job that renders thumbnails; a changed thumbnail
+// time runs it again.
+const thumbnailJobType = "thumbnail"
+
+var ErrThumbnailPastEnd = errors.New("thumbnail timestamp is past the end of the video")
+
+// thumbnailSizes are the JPEG sizes worker-thumbnail rendered next to the
+// full-size "default" frame before it reported its variants.
+var thumbnailSizes = map[string]bool{
+	"small":  true,
+	"medium": true,
+	"large":  true,
+}
+
+// thumbnailContentTypes are the formats a thumbnail variant may be in. Without
+// ?format=, the format the client accepts with the highest q-value is served,
+// ties going to the first one in thumbnailPreference.
+var thumbnailContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+	"avif": "image/avif",
+}
+
+var thumbnailPreference = []string{"avif", "webp", "jpeg"}
+
+// ThumbnailVariant is one rendered size and format of a video's thumbnail.
+type ThumbnailVariant struct {
+	Size   string `json:"size"`
+	Format string `json:"format"`
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// placeholderThumbnail is served while the worker has not produced a frame,
+// captioned with label.
+const placeholderThumbnail = `<svg xmlns="http://www.w3.org/2000/svg" width="320" height="180" viewBox="0 0 320 180">` +
+	`<rect width="320" height="180" fill="#1f2937"/>` +
+	`<text x="160" y="96" fill="#9ca3af" font-family="sans-serif" font-size="16" text-anchor="middle">%s</text>` +
+	`</svg>`
+
+func ThumbnailKey(videoID, size string) string {
+	if size == "" {
+		size = "default"
+	}
+	return DerivedPrefix(videoID) + "thumbnails/" + size + ".jpg"
+}
+
+// ThumbnailHandler serves /videos/{id}/thumbnail[?size=small|medium|large]
+// [&format=jpeg|webp|avif], picking the format from Accept if none is given.
+// A missing size variant falls back to the full-size frame, and a video with
+// no thumbnail yet gets an uncacheable placeholder.
+func ThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	meta, err := raftNode.GetVideoMetadata(mux.Vars(r)["id"])
+	if err != nil || !viewable(meta) {
+		http.Error(w, "video not found", http.StatusNotFound)
+		return
+	}
+
+	size := r.URL.Query().Get("size")
+	format := r.URL.Query().Get("format")
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if format != "" && thumbnailContentTypes[format] == "" {
+		http.Error(w, "format must be one of jpeg, webp, avif", http.StatusBadRequest)
+		return
+	}
+
+	if len(meta.ThumbnailVariants) > 0 {
+		serveThumbnailVariant(w, r, meta, size, format)
+		return
+	}
+	if size != "" && !thumbnailSizes[size] {
+		http.Error(w, "size must be one of small, medium, large", http.StatusBadRequest)
+		return
+	}
+	if format != "" && format != "jpeg" {
+		http.Error(w, "no "+format+" thumbnail for this video", http.StatusNotFound)
+		return
+	}
+
+	candidates := []string{ThumbnailKey(meta.ID, size)}
+	if size != "" {
+		candidates = append(candidates, ThumbnailKey(meta.ID, ""))
+	}
+	candidates = append(candidates, legacyThumbnailKey(meta.Object))
+
+	for _, key := range candidates {
+		_, err := objectStore.Stat(r.Context(), meta.Bucket, key)
+		if errors.Is(err, storage.ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, "Failed to read thumbnail: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		// Thumbnails can be regenerated, so keep max-age short and let the
+		// ETag do the rest.
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		serveObject(w, r, meta.Bucket, key, "image/jpeg")
+		return
+	}
+
+	status := thumbnailStatus(meta)
+	label := "Processing…"
+	switch status {
+	case JobFailed, JobSkipped, JobCanceled, JobReady:
+		label = "No thumbnail"
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Thumbnail-Status", status)
+	fmt.Fprintf(w, placeholderThumbnail, label)
+}
+
+// thumbnailStatus is the state of the video's thumbnail job, or the video's
+// own status if its pipeline renders no thumbnail.
+func thumbnailStatus(meta VideoMetadata) string {
+	if job, ok := meta.Jobs[thumbnailJobType]; ok {
+		return job.Status
+	}
+	return meta.Status
+}
+
+// serveThumbnailVariant serves the reported variant of size (or the full-size
+// frame) in format, or in the best format the client accepts.
+func serveThumbnailVariant(w http.ResponseWriter, r *http.Request, meta VideoMetadata, size, format string) {
+	if size == "" {
+		size = "default"
+	}
+	sizes := map[string]bool{}
+	for _, v := range meta.ThumbnailVariants {
+		sizes[v.Size] = true
+	}
+	if !sizes[size] {
+		http.Error(w, "unknown thumbnail size "+size, http.StatusBadRequest)
+		return
+	}
+
+	formats := []string{format}
+	if format == "" {
+		w.Header().Set("Vary", "Accept")
+		formats = acceptedThumbnailFormats(r.Header.Get("Accept"))
+		if len(formats) == 0 {
+			http.Error(w, "no acceptable thumbnail format; jpeg, webp and avif are available", http.StatusNotAcceptable)
+			return
+		}
+	}
+	for _, f := range formats {
+		for _, v := range meta.ThumbnailVariants {
+			if v.Size == size && v.Format == f {
+				w.Header().Set("Cache-Control", "public, max-age=3600")
+				serveObject(w, r, meta.Bucket, v.Key, thumbnailContentTypes[f])
+				return
+			}
+		}
+	}
+	http.Error(w, "no matching thumbnail for this video", http.StatusNotFound)
+}
+
+// acceptedThumbnailFormats orders the formats accept allows, best first.
+// WebP and AVIF are only served to clients that name them, since one sending
+// */* may not decode them; JPEG is the fallback unless refused with q=0.
+func acceptedThumbnailFormats(accept string) []string {
+	ranges := parseAccept(accept)
+	var formats []string
+	quality := map[string]float64{}
+	jpegMatched := false
+	for _, f := range thumbnailPreference {
+		q, ok := acceptQuality(ranges, thumbnailContentTypes[f], f == "jpeg")
+		if f == "jpeg" {
+			jpegMatched = ok
+		}
+		if ok && q > 0 {
+			formats = append(formats, f)
+			quality[f] = q
+		}
+	}
+	sort.SliceStable(formats, func(i, j int) bool {
+		return quality[formats[i]] > quality[formats[j]]
+	})
+	if !jpegMatched {
+		formats = append(formats, "jpeg")
+	}
+	return formats
+}
+
+type mediaRange struct {
+	typ, subtype string
+	q            float64
+}
+
+// parseAccept parses an Accept header, skipping malformed ranges.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		typ, subtype, ok := strings.Cut(strings.ToLower(strings.TrimSpace(params[0])), "/")
+		if !ok || typ == "" || subtype == "" {
+			continue
+		}
+		mr := mediaRange{typ: typ, subtype: subtype, q: 1}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || q < 0 || q > 1 {
+					ok = false
+				}
+				mr.q = q
+			}
+		}
+		if ok {
+			ranges = append(ranges, mr)
+		}
+	}
+	return ranges
+}
+
+// acceptQuality returns the q-value of the most specific range matching
+// contentType, and whether any did. Wildcard ranges only count if wildcards
+// is set.
+func acceptQuality(ranges []mediaRange, contentType string, wildcards bool) (float64, bool) {
+	typ, subtype, _ := strings.Cut(contentType, "/")
+	best, q := -1, 0.0
+	for _, mr := range ranges {
+		specificity := -1
+		switch {
+		case mr.typ == typ && mr.subtype == subtype:
+			specificity = 2
+		case !wildcards:
+		case mr.typ == typ && mr.subtype == "*":
+			specificity = 1
+		case mr.typ == "*" && mr.subtype == "*":
+			specificity = 0
+		}
+		if specificity > best {
+			best, q = specificity, mr.q
+		}
+	}
+	return q, best >= 0
+}
+
+// jobParams returns the options sent to a worker along with a job request.
+func jobParams(meta VideoMetadata, jobType string) map[string]string {
+	if jobType == thumbnailJobType && meta.ThumbnailAt != nil {
+		return map[string]string{"timestamp": strconv.FormatFloat(*meta.ThumbnailAt, 'f', -1, 64)}
+	}
+	return nil
+}
+
+// applySetThumbnailAt records the user's thumbnail time and renders the
+// thumbnail again with it.
+func (r *RaftNode) applySetThumbnailAt(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok || meta.DeletedAt != nil {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+	if cmd.ThumbnailAt != nil && meta.Probe != nil && meta.Probe.DurationSeconds > 0 && *cmd.ThumbnailAt > meta.Probe.DurationSeconds {
+		return ApplyResult{Video: meta}, ErrThumbnailPastEnd
+	}
+
+	if cmd.ThumbnailAt != nil {
+		at := *cmd.ThumbnailAt
+		meta.ThumbnailAt = &at
+	} else {
+		meta.ThumbnailAt = nil
+	}
+	if _, ok := meta.Jobs[thumbnailJobType]; ok {
+		r.reprocessJobs(cmd, &meta, videoPipeline(meta).withDependents([]string{thumbnailJobType}))
+	}
+	r.videos[meta.ID] = meta
+
+	return ApplyResult{Video: meta}, nil
+}
+
+// SetThumbnailHandler serves PUT /videos/{id}/thumbnail with a body of
+// {"timestamp": seconds} to pick the frame, or {"timestamp": null} to go back
+// to automatic selection.
+func SetThumbnailHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Timestamp *float64 `json:"timestamp"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Timestamp != nil && *req.Timestamp < 0 {
+		http.Error(w, "timestamp must be a non-negative number of seconds", http.StatusUnprocessableEntity)
+		return
+	}
+
+	res, err := raftNode.Propose(Command{
+		Type:        CmdSetThumbnailAt,
+		VideoID:     mux.Vars(r)["id"],
+		ThumbnailAt: req.Timestamp,
+		TraceParent: events.ChildTraceParent(r.Header.Get(events.TraceParentHeader)),
+	})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+	outboxRelay.Notify()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res.Video)
+}
+
+// applySetThumbnails replaces the thumbnail variants recorded for a video.
+func (r *RaftNode) applySetThumbnails(cmd Command) (ApplyResult, error) {
+	meta, ok := r.videos[cmd.VideoID]
+	if !ok {
+		return ApplyResult{}, ErrVideoNotFound
+	}
+
+	meta.ThumbnailVariants = append([]ThumbnailVariant(nil), cmd.Thumbnails...)
+	r.videos[meta.ID] = meta
+
+	return ApplyResult{Video: meta}, nil
+}
+
+type thumbnailsReport struct {
+	Thumbnails []ThumbnailVariant `json:"thumbnails"`
+}
+
+// ThumbnailsCallbackHandler lets worker-thumbnail report the variants it
+// rendered. Keys must lie under the video's thumbnail prefix, since they are
+// served as is.
+func ThumbnailsCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if !raftNode.IsLeader() {
+		http.Error(w, "Not the leader - please route through gateway", http.StatusServiceUnavailable)
+		return
+	}
+
+	var report thumbnailsReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&report); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	videoID := mux.Vars(r)["id"]
+	prefix := DerivedPrefix(videoID) + "thumbnails/"
+	for _, v := range report.Thumbnails {
+		if v.Size == "" || thumbnailContentTypes[v.Format] == "" || !strings.HasPrefix(v.Key, prefix) || strings.Contains(v.Key, "..") {
+			http.Error(w, fmt.Sprintf("invalid thumbnail %s/%s at %q", v.Size, v.Format, v.Key), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	res, err := raftNode.Propose(Command{
+		Type:       CmdSetThumbnails,
+		VideoID:    videoID,
+		Thumbnails: report.Thumbnails,
+	})
+	if err != nil {
+		writeCommandError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(res.Video)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestThumbnailPlaceholderReportsJobStatus(t *testing.T) {
+	srv := startTestNode(t, Config{MinIOBucket: "videos"})
+	storeTestVideo(t, "v1", VisibilityPublic)
+
+	check := func(method, want string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+"/videos/v1/thumbnail", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/svg+xml" {
+			t.Errorf("%s thumbnail = %d %s, want the placeholder", method, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if got := resp.Header.Get("X-Thumbnail-Status"); got != want {
+			t.Errorf("%s thumbnail status = %q, want %q", method, got, want)
+		}
+	}
+	check(http.MethodGet, JobQueued)
+
+	job := &JobState{Status: JobFailed, Error: "no frames"}
+	if _, err := raftNode.Propose(Command{Type: CmdSetJobStatus, VideoID: "v1", JobType: thumbnailJobType, Job: job}); err != nil {
+		t.Fatal(err)
+	}
+	check(http.MethodGet, JobFailed)
+	check(http.MethodHead, JobFailed)
+}
+
+func TestAcceptedThumbnailFormats(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		want   []string
+	}{
+		{"", []string{"jpeg"}},
+		{"*/*", []string{"jpeg"}},
+		{"image/avif,image/webp,*/*;q=0.8", []string{"avif", "webp", "jpeg"}},
+		{"image/webp;q=0.9, image/avif;q=0.5", []string{"webp", "avif", "jpeg"}},
+		{"image/avif;q=0, image/webp", []string{"webp", "jpeg"}},
+		{"IMAGE/WEBP; Q=1", []string{"webp", "jpeg"}},
+		{"image/jpeg;q=0.5, image/webp", []string{"webp", "jpeg"}},
+		{"image/jpeg;q=1, image/webp;q=0.5", []string{"jpeg", "webp"}},
+		{"image/webp, image/*;q=0", []string{"webp"}},
+		{"image/webp, image/jpeg;q=0, image/*", []string{"webp"}},
+		{"image/webp;q=2, image/avif;q=x", []string{"jpeg"}},
+		{"image/jpeg;q=0", nil},
+	} {
+		got := acceptedThumbnailFormats(tc.accept)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("Accept %q: formats = %v, want %v", tc.accept, got, tc.want)
+		}
+	}
+}
+
+func TestThumbnailNegotiatesFormat(t *testing.T) {
+	srv := startTestNode(t, Config{MinIOBucket: "videos"})
+	storeTestVideo(t, "v1", VisibilityPublic)
+	var variants []ThumbnailVariant
+	for _, format := range []string{"jpeg", "webp"} {
+		key := ThumbnailKey("v1", "default") + "." + format
+		if _, err := objectStore.Put(context.Background(), "videos", key, strings.NewReader(format), int64(len(format)), thumbnailContentTypes[format]); err != nil {
+			t.Fatal(err)
+		}
+		variants = append(variants, ThumbnailVariant{Size: "default", Format: format, Key: key})
+	}
+	if _, err := raftNode.Propose(Command{Type: CmdSetThumbnails, VideoID: "v1", Thumbnails: variants}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		accept, query string
+		status        int
+		contentType   string
+	}{
+		{"", "", http.StatusOK, "image/jpeg"},
+		{"image/avif,image/webp,*/*;q=0.8", "", http.StatusOK, "image/webp"},
+		{"image/webp;q=0, */*", "", http.StatusOK, "image/jpeg"},
+		{"image/avif, image/jpeg;q=0.1", "", http.StatusOK, "image/jpeg"},
+		{"image/jpeg;q=0", "", http.StatusNotAcceptable, ""},
+		{"image/webp", "?format=jpeg", http.StatusOK, "image/jpeg"},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/videos/v1/thumbnail"+tc.query, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status || (tc.contentType != "" && resp.Header.Get("Content-Type") != tc.contentType) {
+			t.Errorf("Accept %q%s: %d %s, want %d %s", tc.accept, tc.query, resp.StatusCode, resp.Header.Get("Content-Type"), tc.status, tc.contentType)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const trashPurgeInterval = time.Minute
+
+// DerivedPrefix is the storage prefix under which processors write renditions
+// and other artefacts for a video.
+func DerivedPrefix(videoID string) string {
+	return "derived/" + videoID + "/"
+}
+
+// legacyThumbnailKey is where worker-thumbnail writes its JPEG, keyed by the
+// object name rather than the video ID.
+func legacyThumbnailKey(object string) string {
+	base := filepath.Base(object)
+	return "thumbnails/" + strings.TrimSuffix(base, filepath.Ext(base)) + ".jpg"
+}
+
+// PurgeVideoObjects removes the original upload and everything derived from
+// it. Missing objects are not an error, so a purge can be retried.
+func PurgeVideoObjects(ctx context.Context, meta VideoMetadata) error {
+	keys := []string{meta.Object, legacyThumbnailKey(meta.Object)}
+
+	derived, err := objectStore.List(ctx, meta.Bucket, DerivedPrefix(meta.ID))
+	if err != nil {
+		return err
+	}
+	for _, obj := range derived {
+		keys = append(keys, obj.Key)
+	}
+
+	for _, key := range keys {
+		if err := objectStore.Delete(ctx, meta.Bucket, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HardDeleteVideo purges a video's objects and then removes its record.
+func HardDeleteVideo(node *RaftNode, meta VideoMetadata) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := PurgeVideoObjects(ctx, meta); err != nil {
+		return err
+	}
+	_, err := node.Propose(Command{Type: CmdPurgeVideo, VideoID: meta.ID})
+	return err
+}
+
+// StartTrashPurger hard-deletes videos whose trash retention has expired.
+// Only the leader purges.
+func StartTrashPurger(node *RaftNode, retention time.Duration) {
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if !node.IsLeader() {
+				continue
+			}
+			for _, meta := range node.ListTrash() {
+				if time.Since(*meta.DeletedAt) < retention {
+					continue
+				}
+				if err := HardDeleteVideo(node, meta); err != nil {
+					log.Printf("trash: failed to purge video %s: %v", meta.ID, err)
+					continue
+				}
+				log.Printf("trash: purged video %s", meta.ID)
+			}
+		}
+	}()
+}
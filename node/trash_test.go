@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"events"
+)
+
+func TestDeleteAndRestoreVideo(t *testing.T) {
+	r := newTestRaft()
+	if _, err := r.Propose(Command{Type: CmdStoreVideo, Video: testVideo("v1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Propose(Command{Type: CmdRestoreVideo, VideoID: "v1"}); !errors.Is(err, ErrNotInTrash) {
+		t.Errorf("restoring a live video = %v, want %v", err, ErrNotInTrash)
+	}
+
+	if _, err := r.Propose(Command{Type: CmdDeleteVideo, VideoID: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if trash := r.ListTrash(); len(trash) != 1 || trash[0].ID != "v1" {
+		t.Errorf("trash = %+v, want v1", trash)
+	}
+	res, err := r.Propose(Command{Type: CmdDeleteVideo, VideoID: "v1"})
+	if err != nil || !res.Duplicate {
+		t.Errorf("deleting twice = %v (duplicate %t), want a duplicate", err, res.Duplicate)
+	}
+
+	res, err = r.Propose(Command{Type: CmdRestoreVideo, VideoID: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Video.DeletedAt != nil || len(r.ListTrash()) != 0 {
+		t.Errorf("restored video still in the trash: %+v", res.Video)
+	}
+}
+
+func TestPurgeDropsPendingEvents(t *testing.T) {
+	r := newTestRaft()
+	for _, id := range []string{"v1", "v2"} {
+		if _, err := r.Propose(Command{Type: CmdStoreVideo, Video: testVideo(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Propose(Command{Type: CmdDeleteVideo, VideoID: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Propose(Command{Type: CmdPurgeVideo, VideoID: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var left []string
+	for _, ev := range r.PendingEvents() {
+		env, err := events.Decode(ev.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.VideoID == "v1" {
+			left = append(left, ev.RoutingKey)
+		}
+	}
+	sort.Strings(left)
+	if want := []string{"job.canceled.probe", "job.canceled.thumbnail"}; strings.Join(left, ",") != strings.Join(want, ",") {
+		t.Errorf("pending events of the purged video = %v, want only %v", left, want)
+	}
+	if n := len(r.PendingEvents()) - len(left); n != 2 {
+		t.Errorf("%d pending events of v2, want its 2 job requests", n)
+	}
+}
+
+func TestTrashHandlers(t *testing.T) {
+	srv := startTestNode(t, Config{MinIOBucket: "videos"})
+	for id, visibility := range map[string]string{"pub": VisibilityPublic, "unl": VisibilityUnlisted, "priv": VisibilityPrivate} {
+		video := testVideo(id)
+		video.Visibility = visibility
+		if _, err := raftNode.Propose(Command{Type: CmdStoreVideo, Video: video, IdempotencyKey: "key-" + id}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := raftNode.Propose(Command{Type: CmdDeleteVideo, VideoID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var trash []VideoMetadata
+	err = json.NewDecoder(resp.Body).Decode(&trash)
+	resp.Body.Close()
+	if err != nil || len(trash) != 1 || trash[0].ID != "pub" {
+		t.Errorf("GET /trash = %+v, %v; want only the public video", trash, err)
+	}
+
+	// Retrying the upload of a trashed video must not hand it back.
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/upload", strings.NewReader(""))
+	req.Header.Set("Idempotency-Key", "key-pub")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("upload replay of a trashed video = %d, want %d", resp.StatusCode, http.StatusGone)
+	}
+}
@@ -0,0 +1,161 @@
+// Package mq is the message broker the node publishes events on and the
+// workers consume jobs from, backed by RabbitMQ or, for running without it,
+// an in-process broker.
+package mq
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBrokerDown    = errors.New("broker: not connected")
+	ErrUnroutable    = errors.New("broker: message returned as unroutable")
+	ErrPublishNacked = errors.New("broker: publish nacked")
+	ErrDrained       = errors.New("broker: drained")
+)
+
+// Message is what travels through the broker. ID stays the same across
+// retries and is how a dead letter is picked for replay.
+type Message struct {
+	ID          string
+	ContentType string
+	Timestamp   time.Time
+	Body        []byte
+}
+
+// Delivery is a received message. The handler must settle it with exactly
+// one of Ack, Nack, Retry or DeadLetter.
+type Delivery interface {
+	Message() Message
+	// Attempts counts the earlier failed attempts recorded by Retry.
+	Attempts() int
+	Ack() error
+	// Nack hands the message back unprocessed without counting an attempt.
+	Nack() error
+	// Retry redelivers the message after delay, recording reason.
+	Retry(delay time.Duration, reason error) error
+	// DeadLetter parks the message on the queue's dead-letter queue.
+	DeadLetter(reason error) error
+}
+
+// Subscription binds a durable queue to the routing keys matching Pattern,
+// using AMQP topic syntax: "video.uploaded", "video.*" or "#". Every queue
+// bound gets its own copy of each message; subscribers sharing a queue
+// compete for them.
+type Subscription struct {
+	Pattern     string
+	Queue       string
+	Concurrency int
+	// Prefetch caps unsettled deliveries; it defaults to Concurrency.
+	Prefetch int
+	// Transient queues belong to this process and go away with it, so each
+	// instance gets its own copy of a broadcast. They have no dead letters.
+	Transient bool
+}
+
+type DeadLetter struct {
+	ID        string          `json:"id"`
+	Queue     string          `json:"queue"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error"`
+	FailedAt  string          `json:"failed_at"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// Status is the broker connection state reported by health endpoints.
+type Status struct {
+	Backend   string    `json:"backend"`
+	Connected bool      `json:"connected"`
+	Since     time.Time `json:"since"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Broker carries events from the node to the workers. Messages are routed by
+// key to every queue whose pattern matches; a message no queue matches is
+// rejected with ErrUnroutable.
+type Broker interface {
+	// Publish returns once the broker has taken responsibility for msg.
+	Publish(ctx context.Context, key string, msg Message) error
+	// Bind declares a durable queue and binds it to pattern without
+	// consuming, so messages are kept until a consumer subscribes.
+	Bind(queue, pattern string) error
+	// Subscribe runs handler for each delivery on up to sub.Concurrency
+	// goroutines, until Drain or Close.
+	Subscribe(sub Subscription, handler func(Delivery)) error
+	// Drain stops all subscriptions for good and waits up to timeout for
+	// running handlers, reporting whether they finished. It may be called
+	// again to keep waiting; Subscribe fails with ErrDrained afterwards.
+	Drain(timeout time.Duration) bool
+	DeadLetters(queue string, limit int) ([]DeadLetter, error)
+	// ReplayDeadLetters republishes queue's dead letters with the routing
+	// key they were first published with and a fresh retry budget; all of
+	// them, or only id if it is not empty.
+	ReplayDeadLetters(queue, id string) (int, error)
+	Health() Status
+	Close() error
+}
+
+// Open connects to the broker backend: "rabbitmq", publishing on exchange,
+// or "memory".
+func Open(backend, url, exchange string) (Broker, error) {
+	switch backend {
+	case "rabbitmq":
+		return NewAMQPBroker(url, exchange)
+	case "memory":
+		log.Println("using in-memory broker; events are not shared with other processes")
+		return NewMemoryBroker(), nil
+	default:
+		return nil, fmt.Errorf("unknown broker backend %q", backend)
+	}
+}
+
+// MaxDeadLettersListed caps how many dead letters one listing returns.
+const MaxDeadLettersListed = 500
+
+// topicMatches reports whether key matches an AMQP topic pattern, where "*"
+// stands for exactly one dot-separated word and "#" for zero or more.
+func topicMatches(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	if len(pattern) == 0 {
+		return len(key) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(key); i++ {
+			if matchWords(pattern[1:], key[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(key) > 0 && matchWords(pattern[1:], key[1:])
+	default:
+		return len(key) > 0 && pattern[0] == key[0] && matchWords(pattern[1:], key[1:])
+	}
+}
+
+func deadLetterBody(body []byte) json.RawMessage {
+	if json.Valid(body) {
+		return body
+	}
+	b, _ := json.Marshal(string(body))
+	return b
+}
+
+// NewMessageID returns a random message id.
+func NewMessageID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,332 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MemoryBroker implements Broker inside the process, for running a service
+// without RabbitMQ (local development, tests). Messages do not survive a
+// restart and are not shared with other processes.
+type MemoryBroker struct {
+	mu       sync.Mutex
+	queues   map[string]*memQueue
+	bindings map[string]map[string]bool // pattern -> queue names
+	since    time.Time
+
+	// transient names the queues of Transient subscriptions, which go
+	// away on Drain.
+	transient    []string
+	transientSeq int
+
+	handlers sync.WaitGroup
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type memMessage struct {
+	Message
+	key       string
+	attempts  int
+	lastError string
+	failedAt  string
+}
+
+type memQueue struct {
+	name    string
+	pending []memMessage
+	dead    []memMessage
+	ready   chan struct{} // holds a token while pending may be non-empty
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues:   map[string]*memQueue{},
+		bindings: map[string]map[string]bool{},
+		since:    time.Now().UTC(),
+		stop:     make(chan struct{}),
+	}
+}
+
+// queue returns the named queue, creating it if needed. Callers hold b.mu.
+func (b *MemoryBroker) queue(name string) *memQueue {
+	q, ok := b.queues[name]
+	if !ok {
+		q = &memQueue{name: name, ready: make(chan struct{}, 1)}
+		b.queues[name] = q
+	}
+	return q
+}
+
+func (b *MemoryBroker) bind(pattern, queue string) *memQueue {
+	if b.bindings[pattern] == nil {
+		b.bindings[pattern] = map[string]bool{}
+	}
+	b.bindings[pattern][queue] = true
+	return b.queue(queue)
+}
+
+func (b *MemoryBroker) Bind(queue, pattern string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bind(pattern, queue)
+	return nil
+}
+
+// push appends m to q, or puts it back at the front when requeueing.
+// Callers hold b.mu.
+func (q *memQueue) push(m memMessage, front bool) {
+	if front {
+		q.pending = append([]memMessage{m}, q.pending...)
+	} else {
+		q.pending = append(q.pending, m)
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (b *MemoryBroker) Publish(_ context.Context, key string, msg Message) error {
+	if msg.ID == "" {
+		msg.ID = NewMessageID()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.route(memMessage{Message: msg, key: key})
+}
+
+// route pushes m onto every queue bound to its key. Callers hold b.mu.
+func (b *MemoryBroker) route(m memMessage) error {
+	key := m.key
+	// A queue bound by several matching patterns still gets one copy.
+	targets := map[string]bool{}
+	for pattern, queues := range b.bindings {
+		if topicMatches(pattern, key) {
+			for name := range queues {
+				targets[name] = true
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("%w: %s", ErrUnroutable, key)
+	}
+	for name := range targets {
+		b.queues[name].push(m, false)
+	}
+	return nil
+}
+
+// next blocks until q has a message or the broker stops.
+func (b *MemoryBroker) next(q *memQueue) (memMessage, bool) {
+	for {
+		b.mu.Lock()
+		if len(q.pending) > 0 {
+			m := q.pending[0]
+			q.pending = q.pending[1:]
+			if len(q.pending) > 0 {
+				select {
+				case q.ready <- struct{}{}:
+				default:
+				}
+			}
+			b.mu.Unlock()
+			return m, true
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-b.stop:
+			return memMessage{}, false
+		}
+	}
+}
+
+// Subscribe gives a Transient subscription a queue of its own, so every
+// subscriber gets a copy of each message, like a RabbitMQ exclusive queue.
+// Up to sub.Prefetch deliveries are handed out before earlier ones are
+// settled.
+func (b *MemoryBroker) Subscribe(sub Subscription, handler func(Delivery)) error {
+	if sub.Concurrency <= 0 {
+		sub.Concurrency = 1
+	}
+	if sub.Prefetch <= 0 {
+		sub.Prefetch = sub.Concurrency
+	}
+
+	b.mu.Lock()
+	select {
+	case <-b.stop:
+		b.mu.Unlock()
+		return ErrDrained
+	default:
+	}
+	name := sub.Queue
+	if sub.Transient {
+		b.transientSeq++
+		name = fmt.Sprintf("%s~%d", sub.Queue, b.transientSeq)
+		b.transient = append(b.transient, name)
+	}
+	q := b.bind(sub.Pattern, name)
+	b.mu.Unlock()
+
+	unsettled := make(chan struct{}, sub.Prefetch)
+	for i := 0; i < sub.Concurrency; i++ {
+		b.handlers.Add(1)
+		go func() {
+			defer b.handlers.Done()
+			for {
+				select {
+				case unsettled <- struct{}{}:
+				case <-b.stop:
+					return
+				}
+				m, ok := b.next(q)
+				if !ok {
+					<-unsettled
+					return
+				}
+				handler(&memDelivery{broker: b, queue: q, m: m, settled: func() { <-unsettled }})
+			}
+		}()
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Drain(timeout time.Duration) bool {
+	b.stopOnce.Do(func() { close(b.stop) })
+
+	// Transient queues belong to the subscriptions just stopped.
+	b.mu.Lock()
+	for _, name := range b.transient {
+		for _, queues := range b.bindings {
+			delete(queues, name)
+		}
+		delete(b.queues, name)
+	}
+	b.transient = nil
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (b *MemoryBroker) DeadLetters(queue string, limit int) ([]DeadLetter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	letters := []DeadLetter{}
+	for _, m := range b.queue(queue).dead {
+		if len(letters) == limit {
+			break
+		}
+		letters = append(letters, DeadLetter{
+			ID:        m.ID,
+			Queue:     queue,
+			Attempts:  m.attempts,
+			LastError: m.lastError,
+			FailedAt:  m.failedAt,
+			Body:      deadLetterBody(m.Body),
+		})
+	}
+	return letters, nil
+}
+
+func (b *MemoryBroker) ReplayDeadLetters(queue, id string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q := b.queue(queue)
+	var kept []memMessage
+	replayed := 0
+	for i, m := range q.dead {
+		if id != "" && m.ID != id {
+			kept = append(kept, m)
+			continue
+		}
+		// A replayed job starts with a fresh retry budget.
+		if err := b.route(memMessage{Message: m.Message, key: m.key, lastError: m.lastError}); err != nil {
+			q.dead = append(kept, q.dead[i:]...)
+			return replayed, err
+		}
+		replayed++
+	}
+	q.dead = kept
+	return replayed, nil
+}
+
+func (b *MemoryBroker) Health() Status {
+	return Status{Backend: "memory", Connected: true, Since: b.since}
+}
+
+func (b *MemoryBroker) Close() error {
+	b.stopOnce.Do(func() { close(b.stop) })
+	return nil
+}
+
+type memDelivery struct {
+	broker *MemoryBroker
+	queue  *memQueue
+	m      memMessage
+
+	// settled frees the delivery's prefetch slot.
+	settled    func()
+	settleOnce sync.Once
+}
+
+func (d *memDelivery) settle() { d.settleOnce.Do(d.settled) }
+
+func (d *memDelivery) Message() Message { return d.m.Message }
+func (d *memDelivery) Attempts() int    { return d.m.attempts }
+
+func (d *memDelivery) Ack() error {
+	d.settle()
+	return nil
+}
+
+func (d *memDelivery) Nack() error {
+	defer d.settle()
+	d.broker.mu.Lock()
+	defer d.broker.mu.Unlock()
+	d.queue.push(d.m, true)
+	return nil
+}
+
+func (d *memDelivery) Retry(delay time.Duration, reason error) error {
+	defer d.settle()
+	m := d.m
+	m.attempts++
+	m.lastError = reason.Error()
+	time.AfterFunc(delay, func() {
+		d.broker.mu.Lock()
+		defer d.broker.mu.Unlock()
+		d.queue.push(m, false)
+	})
+	return nil
+}
+
+func (d *memDelivery) DeadLetter(reason error) error {
+	defer d.settle()
+	m := d.m
+	m.attempts++
+	m.lastError = reason.Error()
+	m.failedAt = time.Now().UTC().Format(time.RFC3339)
+
+	d.broker.mu.Lock()
+	defer d.broker.mu.Unlock()
+	d.queue.dead = append(d.queue.dead, m)
+	return nil
+}
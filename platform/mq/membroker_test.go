@@ -0,0 +1,116 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryBrokerTransientFanOut(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+
+	// Two instances of a service subscribe under the same transient name,
+	// and a durable queue is shared by two competing subscribers.
+	var transient, durable [2]atomic.Int32
+	for i := range transient {
+		i := i
+		sub := Subscription{Pattern: "job.canceled.*", Queue: "jobs.cancel", Transient: true}
+		if err := b.Subscribe(sub, func(d Delivery) { transient[i].Add(1); d.Ack() }); err != nil {
+			t.Fatal(err)
+		}
+		sub = Subscription{Pattern: "job.canceled.*", Queue: "jobs.audit"}
+		if err := b.Subscribe(sub, func(d Delivery) { durable[i].Add(1); d.Ack() }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if err := b.Publish(context.Background(), "job.canceled.probe", Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitFor(t, func() bool {
+		return transient[0].Load() == 3 && transient[1].Load() == 3 && durable[0].Load()+durable[1].Load() == 3
+	})
+
+	// Transient queues go away with their subscriptions.
+	b.Drain(time.Second)
+	if err := b.Publish(context.Background(), "job.canceled.probe", Message{}); err != nil {
+		t.Errorf("publish with the durable queue still bound: %v", err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.queues) != 1 {
+		t.Errorf("queues after drain = %d, want only jobs.audit", len(b.queues))
+	}
+}
+
+func TestMemoryBrokerHonorsPrefetch(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+
+	var (
+		mu        sync.Mutex
+		unsettled []Delivery
+	)
+	sub := Subscription{Pattern: "q", Queue: "q", Concurrency: 4, Prefetch: 2}
+	err := b.Subscribe(sub, func(d Delivery) {
+		// Settled later, as a handler handing work off would.
+		mu.Lock()
+		defer mu.Unlock()
+		unsettled = append(unsettled, d)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := b.Publish(context.Background(), "q", Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(unsettled)
+	}
+	waitFor(t, func() bool { return count() == 2 })
+	time.Sleep(20 * time.Millisecond)
+	if n := count(); n != 2 {
+		t.Fatalf("%d deliveries handed out, want the prefetch of 2", n)
+	}
+
+	mu.Lock()
+	unsettled[0].Ack()
+	unsettled[1].Nack()
+	mu.Unlock()
+	waitFor(t, func() bool { return count() == 4 })
+}
+
+func TestMemoryBrokerDrainIsPermanent(t *testing.T) {
+	b := NewMemoryBroker()
+	defer b.Close()
+
+	if !b.Drain(time.Second) {
+		t.Fatal("drain with no handlers timed out")
+	}
+	err := b.Subscribe(Subscription{Pattern: "q", Queue: "q"}, func(d Delivery) { d.Ack() })
+	if !errors.Is(err, ErrDrained) {
+		t.Errorf("subscribe after drain = %v, want %v", err, ErrDrained)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,663 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	deadLetterExchange = "dead-letter"
+
+	retryCountHeader    = "x-retry-count"
+	lastErrorHeader     = "x-last-error"
+	failedAtHeader      = "x-failed-at"
+	originalQueueHeader = "x-original-queue"
+	// The exchange and routing key a message was first published with;
+	// retries and dead letters travel under others.
+	originalExchangeHeader   = "x-original-exchange"
+	originalRoutingKeyHeader = "x-original-routing-key"
+
+	publishConfirmTimeout = 10 * time.Second
+)
+
+// AMQPBroker implements Broker on RabbitMQ. Messages are published to one
+// durable topic exchange with their routing key; a supervisor rebuilds the
+// connection when it drops and re-registers every subscription on the new
+// channel.
+type AMQPBroker struct {
+	url      string
+	exchange string
+
+	mu      sync.RWMutex
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	returns chan amqp.Return // mandatory messages the broker could not route
+	status  Status
+
+	// Publishing is serialized so confirms and returns can be matched to
+	// the message that caused them.
+	publishMu sync.Mutex
+
+	subsMu      sync.Mutex
+	subs        []*amqpSubscription
+	onReconnect []func()
+
+	handlers sync.WaitGroup
+	draining atomic.Bool
+	closing  atomic.Bool
+}
+
+func NewAMQPBroker(url, exchange string) (*AMQPBroker, error) {
+	b := &AMQPBroker{url: url, exchange: exchange}
+
+	var err error
+	for i := 0; i < 10; i++ {
+		err = b.connect()
+		if err == nil {
+			log.Println("Connected to RabbitMQ")
+			break
+		}
+		log.Printf("RabbitMQ not ready, retrying in 2s... (%v)", err)
+		time.Sleep(2 * time.Second)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ after retries: %w", err)
+	}
+
+	go b.supervise()
+	return b, nil
+}
+
+func (b *AMQPBroker) connect() error {
+	conn, err := amqp.Dial(b.url)
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to open channel: %w", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+	if err := ch.ExchangeDeclare(
+		b.exchange,
+		"topic",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("exchange declare failed: %w", err)
+	}
+	returns := ch.NotifyReturn(make(chan amqp.Return, 16))
+
+	b.mu.Lock()
+	b.conn, b.ch, b.returns = conn, ch, returns
+	b.status = Status{Backend: "rabbitmq", Connected: true, Since: time.Now().UTC()}
+	b.mu.Unlock()
+	return nil
+}
+
+// supervise waits for the connection or channel to die and rebuilds both
+// with backoff, then re-registers the subscriptions.
+func (b *AMQPBroker) supervise() {
+	for {
+		b.mu.RLock()
+		conn, ch := b.conn, b.ch
+		b.mu.RUnlock()
+
+		var reason *amqp.Error
+		select {
+		case reason = <-conn.NotifyClose(make(chan *amqp.Error, 1)):
+		case reason = <-ch.NotifyClose(make(chan *amqp.Error, 1)):
+		}
+		if b.closing.Load() {
+			return
+		}
+
+		msg := "closed"
+		if reason != nil {
+			msg = reason.Error()
+		}
+		log.Printf("RabbitMQ connection lost: %s", msg)
+		b.mu.Lock()
+		b.status = Status{Backend: "rabbitmq", Since: time.Now().UTC(), LastError: msg}
+		b.mu.Unlock()
+		_ = conn.Close()
+
+		backoff := time.Second
+		for {
+			time.Sleep(backoff)
+			if b.closing.Load() {
+				return
+			}
+			err := b.connect()
+			if err == nil {
+				err = b.restartSubscriptions()
+			}
+			if err == nil {
+				log.Println("Reconnected to RabbitMQ")
+				break
+			}
+
+			log.Printf("RabbitMQ reconnect failed, retrying in %s: %v", backoff, err)
+			b.mu.Lock()
+			if b.conn != nil {
+				_ = b.conn.Close()
+			}
+			b.status = Status{Backend: "rabbitmq", Since: b.status.Since, LastError: err.Error()}
+			b.mu.Unlock()
+			if backoff *= 2; backoff > 30*time.Second {
+				backoff = 30 * time.Second
+			}
+		}
+
+		b.subsMu.Lock()
+		hooks := b.onReconnect
+		b.subsMu.Unlock()
+		for _, fn := range hooks {
+			fn()
+		}
+	}
+}
+
+// OnReconnect registers fn to run each time the connection is re-established.
+func (b *AMQPBroker) OnReconnect(fn func()) {
+	b.subsMu.Lock()
+	defer b.subsMu.Unlock()
+	b.onReconnect = append(b.onReconnect, fn)
+}
+
+// channel returns the live channel, or ErrBrokerDown while reconnecting.
+func (b *AMQPBroker) channel() (*amqp.Channel, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if !b.status.Connected {
+		return nil, ErrBrokerDown
+	}
+	return b.ch, nil
+}
+
+func (b *AMQPBroker) Health() Status {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.status
+}
+
+func (b *AMQPBroker) Close() error {
+	b.closing.Store(true)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.status.Connected = false
+	if b.ch != nil {
+		_ = b.ch.Close()
+	}
+	if b.conn != nil {
+		return b.conn.Close()
+	}
+	return nil
+}
+
+// Publish sends msg to the exchange as a mandatory, persistent message and
+// waits for the broker's confirm.
+func (b *AMQPBroker) Publish(ctx context.Context, key string, msg Message) error {
+	return b.publish(ctx, b.exchange, key, amqp.Publishing{
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    msg.ID,
+		Timestamp:    msg.Timestamp,
+		Body:         msg.Body,
+	})
+}
+
+// publish sends p and waits for its confirm.
+func (b *AMQPBroker) publish(ctx context.Context, exchange, key string, p amqp.Publishing) error {
+	b.publishMu.Lock()
+	defer b.publishMu.Unlock()
+
+	b.mu.RLock()
+	ch, returns, connected := b.ch, b.returns, b.status.Connected
+	b.mu.RUnlock()
+	if !connected {
+		return ErrBrokerDown
+	}
+
+	if p.MessageId == "" {
+		p.MessageId = NewMessageID()
+	}
+	ctx, cancel := context.WithTimeout(ctx, publishConfirmTimeout)
+	defer cancel()
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, true, false, p)
+	if err != nil {
+		return err
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("waiting for publish confirm: %w", err)
+	}
+
+	// The broker sends basic.return before the ack of an unroutable message,
+	// so by now any return for it is already buffered.
+	if ret, ok := takeReturn(returns, p.MessageId); ok {
+		return fmt.Errorf("%w: %s: %d %s", ErrUnroutable, key, ret.ReplyCode, ret.ReplyText)
+	}
+	if !acked {
+		return fmt.Errorf("%w: %s", ErrPublishNacked, key)
+	}
+	return nil
+}
+
+func takeReturn(returns <-chan amqp.Return, messageID string) (amqp.Return, bool) {
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				return amqp.Return{}, false
+			}
+			if ret.MessageId == messageID {
+				return ret, true
+			}
+		default:
+			return amqp.Return{}, false
+		}
+	}
+}
+
+// amqpSubscription is one queue subscription. Its deliveries feed handler
+// goroutines that outlive reconnects; only the consumer is re-created.
+type amqpSubscription struct {
+	Subscription
+	jobs   chan amqp.Delivery
+	active sync.WaitGroup // dispatch goroutines
+}
+
+func (s *amqpSubscription) start(ch *amqp.Channel, exchange string) error {
+	if err := bindQueue(ch, exchange, s.Queue, s.Pattern, s.Transient); err != nil {
+		return err
+	}
+	if !s.Transient {
+		if err := declareDeadLetter(ch, s.Queue); err != nil {
+			return err
+		}
+	}
+
+	// Qos applies to consumers started after it on this channel.
+	if err := ch.Qos(s.Prefetch, 0, false); err != nil {
+		return fmt.Errorf("qos failed: %w", err)
+	}
+	deliveries, err := ch.Consume(
+		s.Queue,
+		s.Queue, // consumer tag; queues are subscribed at most once
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	s.active.Add(1)
+	go func() {
+		defer s.active.Done()
+		for d := range deliveries {
+			s.jobs <- d
+		}
+	}()
+	return nil
+}
+
+func bindQueue(ch *amqp.Channel, exchange, queue, pattern string, transient bool) error {
+	_, err := ch.QueueDeclare(
+		queue,
+		!transient, // durable
+		transient,  // auto-delete
+		transient,  // exclusive
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("queue declare failed: %w", err)
+	}
+	if err := ch.QueueBind(queue, pattern, exchange, false, nil); err != nil {
+		return fmt.Errorf("queue bind failed: %w", err)
+	}
+	return nil
+}
+
+func (b *AMQPBroker) Bind(queue, pattern string) error {
+	ch, err := b.channel()
+	if err != nil {
+		return err
+	}
+	return bindQueue(ch, b.exchange, queue, pattern, false)
+}
+
+func (b *AMQPBroker) restartSubscriptions() error {
+	ch, err := b.channel()
+	if err != nil {
+		return err
+	}
+
+	b.subsMu.Lock()
+	defer b.subsMu.Unlock()
+	if b.draining.Load() {
+		return nil
+	}
+	for _, s := range b.subs {
+		if err := s.start(ch, b.exchange); err != nil {
+			return fmt.Errorf("re-register %s: %w", s.Queue, err)
+		}
+	}
+	return nil
+}
+
+func (b *AMQPBroker) Subscribe(sub Subscription, handler func(Delivery)) error {
+	if sub.Concurrency <= 0 {
+		sub.Concurrency = 1
+	}
+	if sub.Prefetch <= 0 {
+		sub.Prefetch = sub.Concurrency
+	}
+	s := &amqpSubscription{Subscription: sub, jobs: make(chan amqp.Delivery)}
+
+	ch, err := b.channel()
+	if err != nil {
+		return err
+	}
+	b.subsMu.Lock()
+	if b.draining.Load() {
+		b.subsMu.Unlock()
+		return ErrDrained
+	}
+	err = s.start(ch, b.exchange)
+	if err == nil {
+		b.subs = append(b.subs, s)
+	}
+	b.subsMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < sub.Concurrency; i++ {
+		b.handlers.Add(1)
+		go func() {
+			defer b.handlers.Done()
+			for d := range s.jobs {
+				if b.draining.Load() {
+					_ = d.Nack(false, true)
+					continue
+				}
+				handler(&amqpDelivery{broker: b, queue: s.Queue, d: d})
+			}
+		}()
+	}
+	return nil
+}
+
+func (b *AMQPBroker) Drain(timeout time.Duration) bool {
+	if !b.draining.Swap(true) {
+		b.subsMu.Lock()
+		ch, err := b.channel()
+		for _, s := range b.subs {
+			if err == nil {
+				if err := ch.Cancel(s.Queue, false); err != nil {
+					log.Printf("cancel consumer %s: %v", s.Queue, err)
+				}
+			}
+		}
+		subs := b.subs
+		b.subsMu.Unlock()
+
+		// Once the broker confirms the cancel the delivery channels close;
+		// closing jobs then lets the handlers exit after their current one.
+		go func() {
+			for _, s := range subs {
+				s.active.Wait()
+				close(s.jobs)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		b.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// amqpDelivery settles on the channel the message arrived on. If that
+// channel died meanwhile the settle fails and the broker redelivers the
+// message after reconnecting.
+type amqpDelivery struct {
+	broker *AMQPBroker
+	queue  string
+	d      amqp.Delivery
+}
+
+func (d *amqpDelivery) Message() Message {
+	return Message{
+		ID:          d.d.MessageId,
+		ContentType: d.d.ContentType,
+		Timestamp:   d.d.Timestamp,
+		Body:        d.d.Body,
+	}
+}
+
+func (d *amqpDelivery) Attempts() int { return retryCount(d.d.Headers) }
+func (d *amqpDelivery) Ack() error    { return d.d.Ack(false) }
+func (d *amqpDelivery) Nack() error   { return d.d.Nack(false, true) }
+
+// Retry parks the message on a per-delay queue whose TTL dead-letters it
+// back onto the original queue. One queue per distinct delay keeps the
+// per-queue TTL exact.
+func (d *amqpDelivery) Retry(delay time.Duration, reason error) error {
+	headers := d.failureHeaders(reason)
+
+	ch, err := d.broker.channel()
+	if err != nil {
+		return err
+	}
+	dq := fmt.Sprintf("%s.delay.%dms", d.queue, delay.Milliseconds())
+	if _, err := ch.QueueDeclare(dq, true, false, false, false, amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": d.queue,
+	}); err != nil {
+		_ = d.Nack()
+		return fmt.Errorf("delay queue declare failed: %w", err)
+	}
+	return d.republish("", dq, headers)
+}
+
+func (d *amqpDelivery) DeadLetter(reason error) error {
+	headers := d.failureHeaders(reason)
+	headers[failedAtHeader] = time.Now().UTC().Format(time.RFC3339)
+	headers[originalQueueHeader] = d.queue
+
+	ch, err := d.broker.channel()
+	if err != nil {
+		return err
+	}
+	if err := declareDeadLetter(ch, d.queue); err != nil {
+		_ = d.Nack()
+		return err
+	}
+	return d.republish(deadLetterExchange, d.queue, headers)
+}
+
+// failureHeaders counts a failed attempt and records where the message was
+// first published, unless an earlier failure did.
+func (d *amqpDelivery) failureHeaders(reason error) amqp.Table {
+	headers := copyHeaders(d.d.Headers)
+	headers[retryCountHeader] = int32(d.Attempts() + 1)
+	headers[lastErrorHeader] = reason.Error()
+	if _, ok := headers[originalRoutingKeyHeader]; !ok {
+		headers[originalExchangeHeader] = d.d.Exchange
+		headers[originalRoutingKeyHeader] = d.d.RoutingKey
+	}
+	return headers
+}
+
+// republish copies the delivery with new headers and acks the original only
+// once the copy is confirmed; otherwise it is requeued.
+func (d *amqpDelivery) republish(exchange, key string, headers amqp.Table) error {
+	err := d.broker.publish(context.Background(), exchange, key, toPublishing(d.d, headers))
+	if err != nil {
+		_ = d.Nack()
+		return err
+	}
+	return d.Ack()
+}
+
+func toPublishing(d amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	id := d.MessageId
+	if id == "" {
+		id = NewMessageID()
+	}
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    id,
+		Timestamp:    d.Timestamp,
+		Type:         d.Type,
+		Body:         d.Body,
+	}
+}
+
+func deadLetterQueue(queue string) string {
+	return queue + ".dead"
+}
+
+func declareDeadLetter(ch *amqp.Channel, queue string) error {
+	if err := ch.ExchangeDeclare(deadLetterExchange, "direct", true, false, false, false, nil); err != nil {
+		return fmt.Errorf("dead-letter exchange declare failed: %w", err)
+	}
+	dlq := deadLetterQueue(queue)
+	if _, err := ch.QueueDeclare(dlq, true, false, false, false, nil); err != nil {
+		return fmt.Errorf("dead-letter queue declare failed: %w", err)
+	}
+	if err := ch.QueueBind(dlq, queue, deadLetterExchange, false, nil); err != nil {
+		return fmt.Errorf("dead-letter queue bind failed: %w", err)
+	}
+	return nil
+}
+
+func retryCount(headers amqp.Table) int {
+	switch v := headers[retryCountHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+func copyHeaders(h amqp.Table) amqp.Table {
+	out := amqp.Table{}
+	for k, v := range h {
+		out[k] = v
+	}
+	return out
+}
+
+// walkDeadLetters fetches up to limit messages from queue's dead-letter queue
+// on a private channel and calls fn for each. Messages fn does not ack are
+// returned to the queue when the channel closes.
+func (b *AMQPBroker) walkDeadLetters(queue string, limit int, fn func(d amqp.Delivery) error) error {
+	b.mu.RLock()
+	conn, connected := b.conn, b.status.Connected
+	b.mu.RUnlock()
+	if !connected {
+		return ErrBrokerDown
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	if err := declareDeadLetter(ch, queue); err != nil {
+		return err
+	}
+	for i := 0; i < limit; i++ {
+		d, ok, err := ch.Get(deadLetterQueue(queue), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *AMQPBroker) DeadLetters(queue string, limit int) ([]DeadLetter, error) {
+	letters := []DeadLetter{}
+	err := b.walkDeadLetters(queue, limit, func(d amqp.Delivery) error {
+		dl := DeadLetter{
+			ID:       d.MessageId,
+			Queue:    queue,
+			Attempts: retryCount(d.Headers),
+			Body:     deadLetterBody(d.Body),
+		}
+		dl.LastError, _ = d.Headers[lastErrorHeader].(string)
+		dl.FailedAt, _ = d.Headers[failedAtHeader].(string)
+		letters = append(letters, dl)
+		return nil
+	})
+	return letters, err
+}
+
+func (b *AMQPBroker) ReplayDeadLetters(queue, id string) (int, error) {
+	replayed := 0
+	err := b.walkDeadLetters(queue, MaxDeadLettersListed, func(d amqp.Delivery) error {
+		if id != "" && d.MessageId != id {
+			return nil
+		}
+		// A replayed job starts with a fresh retry budget.
+		headers := copyHeaders(d.Headers)
+		delete(headers, retryCountHeader)
+		delete(headers, failedAtHeader)
+		// Route it as it was first published, so it reaches the same
+		// queues; letters that predate the recorded route go straight
+		// back to queue.
+		exchange, key := "", queue
+		if k, ok := headers[originalRoutingKeyHeader].(string); ok {
+			exchange, _ = headers[originalExchangeHeader].(string)
+			key = k
+		}
+		if err := b.publish(context.Background(), exchange, key, toPublishing(d, headers)); err != nil {
+			return err
+		}
+		replayed++
+		return d.Ack(false)
+	})
+	return replayed, err
+}
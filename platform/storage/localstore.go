@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// metaDir holds per-object sidecar metadata (content type, etag) so the
+// object tree itself only contains the stored bytes.
+const metaDir = ".meta"
+
+// LocalStore keeps objects on the local filesystem under root/<bucket>/<key>.
+// It exists so the pipeline can run without a MinIO container.
+type LocalStore struct {
+	root string
+}
+
+type localObjectMeta struct {
+	ContentType string `json:"content_type"`
+	ETag        string `json:"etag"`
+}
+
+func NewLocalStore(root string) (*LocalStore, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStore{root: abs}, nil
+}
+
+// bucketPath is the directory of bucket. Bucket names are a single path
+// segment and may not hide behind the metadata directory.
+func (s *LocalStore) bucketPath(bucket string) (string, error) {
+	if bucket == "" || bucket == "." || bucket == ".." || bucket == metaDir || strings.ContainsAny(bucket, `/\`) {
+		return "", fmt.Errorf("invalid bucket %q", bucket)
+	}
+	return filepath.Join(s.root, bucket), nil
+}
+
+// objectPath maps a key to its file. Keys are rejected if any segment is
+// "..", so a key cannot reach outside its bucket; dots elsewhere, as in
+// "a..b.mp4", are fine.
+func (s *LocalStore) objectPath(bucket, key string) (string, error) {
+	dir, err := s.bucketPath(bucket)
+	if err != nil {
+		return "", err
+	}
+	clean := path.Clean("/" + key)
+	if key == "" || clean == "/" {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("invalid object key %q", key)
+		}
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *LocalStore) metaPath(bucket, key string) string {
+	return filepath.Join(s.root, metaDir, bucket, filepath.FromSlash(path.Clean("/"+key))+".json")
+}
+
+func (s *LocalStore) EnsureBucket(_ context.Context, bucket string) error {
+	dir, err := s.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0o755)
+}
+
+func (s *LocalStore) Put(_ context.Context, bucket, key string, r io.Reader, _ int64, contentType string) (ObjectInfo, error) {
+	p, err := s.objectPath(bucket, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := md5.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), r); err != nil {
+		tmp.Close()
+		return ObjectInfo{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return ObjectInfo{}, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return ObjectInfo{}, err
+	}
+
+	meta := localObjectMeta{ContentType: contentType, ETag: hex.EncodeToString(h.Sum(nil))}
+	if err := s.writeMeta(bucket, key, meta); err != nil {
+		return ObjectInfo{}, err
+	}
+	return s.Stat(context.Background(), bucket, key)
+}
+
+func (s *LocalStore) writeMeta(bucket, key string, meta localObjectMeta) error {
+	mp := s.metaPath(bucket, key)
+	if err := os.MkdirAll(filepath.Dir(mp), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(mp, data, 0o644)
+}
+
+func (s *LocalStore) readMeta(bucket, key string) localObjectMeta {
+	var meta localObjectMeta
+	if data, err := os.ReadFile(s.metaPath(bucket, key)); err == nil {
+		_ = json.Unmarshal(data, &meta)
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = mime.TypeByExtension(path.Ext(key))
+	}
+	if meta.ContentType == "" {
+		meta.ContentType = "application/octet-stream"
+	}
+	return meta
+}
+
+func (s *LocalStore) Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := s.Stat(ctx, bucket, key)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	p, _ := s.objectPath(bucket, key)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return f, info, nil
+}
+
+func (s *LocalStore) GetRange(_ context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	p, err := s.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s/%s: %w", bucket, key, ErrObjectNotFound)
+		}
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length <= 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (s *LocalStore) Stat(_ context.Context, bucket, key string) (ObjectInfo, error) {
+	p, err := s.objectPath(bucket, key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ObjectInfo{}, fmt.Errorf("%s/%s: %w", bucket, key, ErrObjectNotFound)
+		}
+		return ObjectInfo{}, err
+	}
+	if fi.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("%s/%s: %w", bucket, key, ErrObjectNotFound)
+	}
+
+	meta := s.readMeta(bucket, key)
+	return ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  meta.ContentType,
+		ETag:         meta.ETag,
+		LastModified: fi.ModTime(),
+	}, nil
+}
+
+func (s *LocalStore) Delete(_ context.Context, bucket, key string) error {
+	p, err := s.objectPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_ = os.Remove(s.metaPath(bucket, key))
+	return nil
+}
+
+func (s *LocalStore) List(_ context.Context, bucket, prefix string) ([]ObjectInfo, error) {
+	base, err := s.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	var out []ObjectInfo
+
+	err = filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		meta := s.readMeta(bucket, key)
+		out = append(out, ObjectInfo{
+			Key:          key,
+			Size:         fi.Size(),
+			ContentType:  meta.ContentType,
+			ETag:         meta.ETag,
+			LastModified: fi.ModTime(),
+		})
+		return nil
+	})
+	return out, err
+}
+
+// Presign returns a file:// URL; there is no server in front of the local
+// store, so the URL is only useful to processes on the same host.
+func (s *LocalStore) Presign(_ context.Context, bucket, key string, _ time.Duration) (string, error) {
+	p, err := s.objectPath(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(p)}).String(), nil
+}
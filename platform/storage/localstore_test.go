@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoreObjectPath(t *testing.T) {
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		bucket, key string
+		ok          bool
+	}{
+		{"videos", "a..b.mp4", true},
+		{"videos", "derived/v1/hls/720p/seg..1.ts", true},
+		{"videos", "./clip.mp4", true},
+		{"videos", "", false},
+		{"videos", "/", false},
+		{"videos", "../other/clip.mp4", false},
+		{"videos", "derived/../../clip.mp4", false},
+		{"videos", "derived/..", false},
+		{"", "clip.mp4", false},
+		{"..", "clip.mp4", false},
+		{"a/b", "clip.mp4", false},
+		{`a\b`, "clip.mp4", false},
+		{metaDir, "clip.mp4", false},
+	} {
+		_, err := s.objectPath(tc.bucket, tc.key)
+		if (err == nil) != tc.ok {
+			t.Errorf("objectPath(%q, %q) error = %v, want ok %t", tc.bucket, tc.key, err, tc.ok)
+		}
+	}
+}
+
+func TestLocalStoreGetRange(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put(ctx, "videos", "clip.mp4", strings.NewReader("0123456789"), 10, "video/mp4"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, "0123456789"},
+		{0, 0, "0123456789"},
+		{4, 0, "456789"},
+		{4, -1, "456789"},
+		{4, 3, "456"},
+	} {
+		r, err := s.GetRange(ctx, "videos", "clip.mp4", tc.offset, tc.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := io.ReadAll(r)
+		r.Close()
+		if string(got) != tc.want {
+			t.Errorf("GetRange(%d, %d) = %q, want %q", tc.offset, tc.length, got, tc.want)
+		}
+	}
+
+	if _, err := s.GetRange(ctx, "videos", "missing.mp4", 0, -1); !errors.Is(err, ErrObjectNotFound) {
+		t.Errorf("GetRange of a missing key = %v, want ErrObjectNotFound", err)
+	}
+}
@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type MinIOStore struct {
+	client *minio.Client
+}
+
+func NewMinIOStore(endpoint, accessKey, secretKey string) (*MinIOStore, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &MinIOStore{client: client}, nil
+}
+
+func (s *MinIOStore) EnsureBucket(ctx context.Context, bucket string) error {
+	exists, err := s.client.BucketExists(ctx, bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err := s.client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MinIOStore) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, contentType string) (ObjectInfo, error) {
+	info, err := s.client.PutObject(ctx, bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         info.Size,
+		ContentType:  contentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+func (s *MinIOStore) Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error) {
+	obj, err := s.client.GetObject(ctx, bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, ObjectInfo{}, minioErr(bucket, key, err)
+	}
+	// GetObject is lazy; Stat surfaces a missing key before we hand it out.
+	st, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, ObjectInfo{}, minioErr(bucket, key, err)
+	}
+	return obj, toObjectInfo(st), nil
+}
+
+func (s *MinIOStore) GetRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	opts, err := rangeOptions(offset, length)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.client.GetObject(ctx, bucket, key, opts)
+	if err != nil {
+		return nil, minioErr(bucket, key, err)
+	}
+	return obj, nil
+}
+
+func rangeOptions(offset, length int64) (minio.GetObjectOptions, error) {
+	opts := minio.GetObjectOptions{}
+	var err error
+	switch {
+	case length > 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case offset > 0:
+		// SetRange(offset, 0) is the open range "bytes=offset-"; from 0 it
+		// would mean the first byte only, so the whole object needs none.
+		err = opts.SetRange(offset, 0)
+	}
+	return opts, err
+}
+
+func (s *MinIOStore) Stat(ctx context.Context, bucket, key string) (ObjectInfo, error) {
+	st, err := s.client.StatObject(ctx, bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return ObjectInfo{}, minioErr(bucket, key, err)
+	}
+	return toObjectInfo(st), nil
+}
+
+func (s *MinIOStore) Delete(ctx context.Context, bucket, key string) error {
+	return s.client.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *MinIOStore) List(ctx context.Context, bucket, prefix string) ([]ObjectInfo, error) {
+	var out []ObjectInfo
+	for obj := range s.client.ListObjects(ctx, bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		out = append(out, toObjectInfo(obj))
+	}
+	return out, nil
+}
+
+func (s *MinIOStore) Presign(ctx context.Context, bucket, key string, expiry time.Duration) (string, error) {
+	u, err := s.client.PresignedGetObject(ctx, bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func toObjectInfo(st minio.ObjectInfo) ObjectInfo {
+	return ObjectInfo{
+		Key:          st.Key,
+		Size:         st.Size,
+		ContentType:  st.ContentType,
+		ETag:         st.ETag,
+		LastModified: st.LastModified,
+	}
+}
+
+func minioErr(bucket, key string, err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("%s/%s: %w", bucket, key, ErrObjectNotFound)
+	}
+	return err
+}
@@ -0,0 +1,25 @@
+package storage
+
+import "testing"
+
+func TestMinIORangeOptions(t *testing.T) {
+	for _, tc := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, ""},
+		{0, 0, ""},
+		{4, 0, "bytes=4-"},
+		{4, -1, "bytes=4-"},
+		{4, 3, "bytes=4-6"},
+		{0, 1, "bytes=0-0"},
+	} {
+		opts, err := rangeOptions(tc.offset, tc.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := opts.Header().Get("Range"); got != tc.want {
+			t.Errorf("rangeOptions(%d, %d) Range = %q, want %q", tc.offset, tc.length, got, tc.want)
+		}
+	}
+}
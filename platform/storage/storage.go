@@ -0,0 +1,62 @@
+// Package storage is the object store holding uploaded videos and everything
+// derived from them, on MinIO or the local filesystem.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ObjectStore is the blob storage used for original uploads and everything
+// derived from them. Implementations return ErrObjectNotFound (possibly
+// wrapped) for missing keys.
+type ObjectStore interface {
+	EnsureBucket(ctx context.Context, bucket string) error
+	Put(ctx context.Context, bucket, key string, r io.Reader, size int64, contentType string) (ObjectInfo, error)
+	Get(ctx context.Context, bucket, key string) (io.ReadCloser, ObjectInfo, error)
+	// GetRange reads length bytes starting at offset. A length of zero or
+	// less reads to the end of the object.
+	GetRange(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, bucket, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, bucket, key string) error
+	List(ctx context.Context, bucket, prefix string) ([]ObjectInfo, error)
+	Presign(ctx context.Context, bucket, key string, expiry time.Duration) (string, error)
+}
+
+// Config selects and configures a storage backend.
+type Config struct {
+	// Backend is "minio" or "local".
+	Backend string
+
+	MinIOEndpoint  string
+	MinIOAccessKey string
+	MinIOSecretKey string
+
+	// LocalPath is the root directory of the local backend.
+	LocalPath string
+}
+
+// Open returns the store cfg selects.
+func Open(cfg Config) (ObjectStore, error) {
+	switch cfg.Backend {
+	case "minio":
+		return NewMinIOStore(cfg.MinIOEndpoint, cfg.MinIOAccessKey, cfg.MinIOSecretKey)
+	case "local":
+		return NewLocalStore(cfg.LocalPath)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"platform/mq"
+)
+
+var (
+	consumedMu     sync.Mutex
+	consumedQueues []string
+)
+
+func registerConsumedQueue(queue string) {
+	consumedMu.Lock()
+	defer consumedMu.Unlock()
+	consumedQueues = append(consumedQueues, queue)
+}
+
+func isConsumedQueue(queue string) bool {
+	consumedMu.Lock()
+	defer consumedMu.Unlock()
+	for _, q := range consumedQueues {
+		if q == queue {
+			return true
+		}
+	}
+	return false
+}
+
+// StartAdminServer exposes:
+//
+//	GET  /healthz                             broker connectivity
+//	GET  /metrics                             job counters (Prometheus format)
+//	GET  /admin/dead-letters?queue=Q          list dead-lettered jobs
+//	POST /admin/dead-letters/replay?queue=Q   re-enqueue all, or one with &id=
+//
+// The /admin/ endpoints require the worker token as a bearer token.
+func StartAdminServer(port string) {
+	mux := adminMux()
+	go func() {
+		log.Printf("admin server listening on :%s", port)
+		if err := http.ListenAndServe(":"+port, mux); err != nil {
+			log.Printf("admin server failed: %v", err)
+		}
+	}()
+}
+
+func adminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		health := broker.Health()
+		status, code := "ok", http.StatusOK
+		if !health.Connected {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": status,
+			"broker": health,
+		})
+	})
+
+	mux.Handle("/metrics", jobMetrics)
+
+	mux.HandleFunc("/admin/dead-letters", requireWorkerToken(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		queue, ok := adminQueue(w, r)
+		if !ok {
+			return
+		}
+
+		letters, err := broker.DeadLetters(queue, mq.MaxDeadLettersListed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(letters)
+	}))
+
+	mux.HandleFunc("/admin/dead-letters/replay", requireWorkerToken(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		queue, ok := adminQueue(w, r)
+		if !ok {
+			return
+		}
+
+		replayed, err := broker.ReplayDeadLetters(queue, r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		log.Printf("admin: replayed %d dead-lettered message(s) onto %s", replayed, queue)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]int{"replayed": replayed})
+	}))
+	return mux
+}
+
+// requireWorkerToken refuses every request while no worker token is set.
+func requireWorkerToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if workerToken == "" {
+			http.Error(w, "admin API disabled: WORKER_TOKEN is not set", http.StatusServiceUnavailable)
+			return
+		}
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(workerToken)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func adminQueue(w http.ResponseWriter, r *http.Request) (string, bool) {
+	queue := r.URL.Query().Get("queue")
+	if queue == "" {
+		consumedMu.Lock()
+		if len(consumedQueues) == 1 {
+			queue = consumedQueues[0]
+		}
+		consumedMu.Unlock()
+	}
+	if !isConsumedQueue(queue) {
+		http.Error(w, fmt.Sprintf("unknown queue %s", strconv.Quote(queue)), http.StatusBadRequest)
+		return "", false
+	}
+	return queue, true
+}
@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"platform/mq"
+)
+
+func TestAdminRequiresWorkerToken(t *testing.T) {
+	mem := mq.NewMemoryBroker()
+	broker = mem
+	t.Cleanup(func() { mem.Close() })
+	registerConsumedQueue("test.admin")
+	srv := httptest.NewServer(adminMux())
+	defer srv.Close()
+
+	cases := []struct {
+		token, auth string
+		want        int
+	}{
+		{"", "", http.StatusServiceUnavailable},
+		{"secret", "", http.StatusUnauthorized},
+		{"secret", "Bearer wrong", http.StatusUnauthorized},
+		{"secret", "Bearer secret", http.StatusOK},
+	}
+	defer func() { workerToken = "" }()
+	for _, c := range cases {
+		workerToken = c.token
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/admin/dead-letters?queue=test.admin", nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.want {
+			t.Errorf("token %q, Authorization %q: status %d, want %d", c.token, c.auth, resp.StatusCode, c.want)
+		}
+	}
+
+	// Health and metrics stay open for probes and scrapers.
+	workerToken = "secret"
+	for _, path := range []string{"/healthz", "/metrics"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s = %d, want 200", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestReplayRepublishesWithRoutingKey(t *testing.T) {
+	mem := mq.NewMemoryBroker()
+	broker = mem
+	t.Cleanup(func() { mem.Close() })
+	workerToken = "secret"
+	defer func() { workerToken = "" }()
+
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	handler := func(d mq.Delivery) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, d.Message().ID)
+		if len(seen) == 1 {
+			return Permanent(errors.New("bad input"))
+		}
+		return nil
+	}
+	policy := RetryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	if err := ConsumeQueue(context.Background(), "job.requested.replay", "test.replay", 1, 1, policy, handler); err != nil {
+		t.Fatal(err)
+	}
+	// The replay is routed by key, so another queue bound to it gets a
+	// copy too.
+	var audited atomic.Int32
+	audit := func(mq.Delivery) error {
+		audited.Add(1)
+		return nil
+	}
+	if err := ConsumeQueue(context.Background(), "job.requested.#", "test.replay.audit", 1, 1, policy, audit); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Publish(context.Background(), "job.requested.replay", mq.Message{ID: "m1", Body: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		letters, _ := mem.DeadLetters("test.replay", 10)
+		return len(letters) == 1
+	})
+
+	srv := httptest.NewServer(adminMux())
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/admin/dead-letters/replay?queue=test.replay", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	err = json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if err != nil || got["replayed"] != 1 {
+		t.Fatalf("replay = %v, %v; want 1 replayed", got, err)
+	}
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(seen) == 2
+	})
+	waitFor(t, func() bool { return audited.Load() == 2 })
+	if letters, _ := mem.DeadLetters("test.replay", 10); len(letters) != 0 {
+		t.Errorf("dead letters after replay = %+v, want none", letters)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"events"
+)
+
+var callbackClient = &http.Client{Timeout: 10 * time.Second}
+
+// workerToken is sent as a bearer token on every callback; set by Init.
+var workerToken string
+
+// ErrJobSuperseded means the node rejected a report because the job was
+// canceled or requested again since this run started.
+var ErrJobSuperseded = errors.New("job run superseded")
+
+const (
+	JobQueued     = "queued"
+	JobProcessing = "processing"
+	JobReady      = "ready"
+	JobFailed     = "failed"
+)
+
+// JobReport is the body of a job status callback. Progress is a fraction
+// between 0 and 1; Error is only meaningful for JobFailed and Result for
+// JobReady. Run is the request being reported on.
+type JobReport struct {
+	Status   string     `json:"status"`
+	Run      int        `json:"run,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Progress float64    `json:"progress,omitempty"`
+	Result   *JobResult `json:"result,omitempty"`
+}
+
+// ReportJobStatus records a job state transition on the video.
+func ReportJobStatus(ctx context.Context, nodeURL, videoID, jobType string, report JobReport) error {
+	return PostCallback(ctx, nodeURL, videoID, "jobs/"+jobType, report)
+}
+
+// PostCallback POSTs payload to /internal/videos/{id}/{kind}. Retries cover
+// leader elections; a 404 means the video was purged meanwhile and is not an
+// error, while a 409 returns ErrJobSuperseded.
+func PostCallback(ctx context.Context, nodeURL, videoID, kind string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/internal/videos/%s/%s", nodeURL, videoID, kind)
+
+	var lastErr error
+	for attempt := 0; attempt < 5; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * 2 * time.Second):
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if workerToken != "" {
+			req.Header.Set("Authorization", "Bearer "+workerToken)
+		}
+		if tp := events.TraceParentFrom(ctx); tp != "" {
+			req.Header.Set(events.TraceParentHeader, events.ChildTraceParent(tp))
+		}
+
+		resp, err := callbackClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusNotFound:
+			return nil
+		case resp.StatusCode == http.StatusConflict:
+			return ErrJobSuperseded
+		case resp.StatusCode == http.StatusUnauthorized:
+			return fmt.Errorf("report %s: %s (check WORKER_TOKEN)", kind, resp.Status)
+		default:
+			lastErr = fmt.Errorf("report %s: %s", kind, resp.Status)
+		}
+	}
+	return lastErr
+}
@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"events"
+	"platform/mq"
+)
+
+// errJobCanceled is the cancel cause of a job the node no longer wants,
+// because it was canceled, reprocessed or its video deleted.
+var errJobCanceled = errors.New("job canceled")
+
+type runningKey struct {
+	videoID, jobType string
+}
+
+type runningJob struct {
+	run    int
+	cancel context.CancelCauseFunc
+}
+
+var (
+	runningMu sync.Mutex
+	running   = map[runningKey][]*runningJob{}
+)
+
+// trackRunning makes the job cancelable by job.canceled events until the
+// returned func is called.
+func trackRunning(job Job, cancel context.CancelCauseFunc) func() {
+	key := runningKey{job.VideoID, job.Type}
+	r := &runningJob{run: job.Run, cancel: cancel}
+
+	runningMu.Lock()
+	running[key] = append(running[key], r)
+	runningMu.Unlock()
+
+	return func() {
+		runningMu.Lock()
+		defer runningMu.Unlock()
+		jobs := running[key]
+		for i, j := range jobs {
+			if j == r {
+				jobs = append(jobs[:i], jobs[i+1:]...)
+				break
+			}
+		}
+		if len(jobs) == 0 {
+			delete(running, key)
+		} else {
+			running[key] = jobs
+		}
+	}
+}
+
+// cancelRunning cancels the runs of a job up to and including run, and
+// reports how many it found.
+func cancelRunning(videoID, jobType string, run int) int {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	n := 0
+	for _, j := range running[runningKey{videoID, jobType}] {
+		if j.run <= run {
+			j.cancel(errJobCanceled)
+			n++
+		}
+	}
+	return n
+}
+
+// subscribeCancellations listens for job.canceled events on a queue of this
+// process's own, since whichever instance runs the job must see them. Jobs
+// still queued need no event: the node refuses their first status report.
+func subscribeCancellations() error {
+	queue := "jobs.cancel." + mq.NewMessageID()[:12]
+	err := broker.Subscribe(mq.Subscription{
+		Pattern:     events.JobRoutingKey(events.TypeJobCanceled, "*"),
+		Queue:       queue,
+		Concurrency: 1,
+		Transient:   true,
+	}, func(d mq.Delivery) {
+		_, msg, err := events.DecodeJobCanceled(d.Message().Body)
+		_ = d.Ack()
+		if err != nil {
+			log.Printf("%s: dropping message: %v", queue, err)
+			return
+		}
+		if n := cancelRunning(msg.VideoID, msg.Job, msg.Run); n > 0 {
+			log.Printf("%s: canceled video %s run %d: %s", msg.Job, msg.VideoID, msg.Run, msg.Reason)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("subscribe %s: %w", queue, err)
+	}
+	return nil
+}
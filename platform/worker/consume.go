@@ -0,0 +1,40 @@
+package worker
+
+import (
+	"context"
+	"log"
+
+	"platform/mq"
+)
+
+// ConsumeQueue binds queue to the routing keys matching pattern and runs
+// handler for every message with up to concurrency in flight. Failed messages
+// are retried with backoff according to policy and then dead-lettered. Once jobCtx is cancelled,
+// failures are treated as aborted work and requeued as-is. The handler must
+// not settle the delivery itself.
+func ConsumeQueue(jobCtx context.Context, pattern, queue string, concurrency, prefetch int, policy RetryPolicy, handler func(mq.Delivery) error) error {
+	err := broker.Subscribe(mq.Subscription{
+		Pattern:     pattern,
+		Queue:       queue,
+		Concurrency: concurrency,
+		Prefetch:    prefetch,
+	}, func(d mq.Delivery) {
+		err := handler(d)
+		switch {
+		case err == nil:
+			_ = d.Ack()
+		case jobCtx.Err() != nil:
+			log.Printf("%s: job aborted by shutdown, requeueing", queue)
+			_ = d.Nack()
+		default:
+			handleFailure(queue, policy, d, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	registerConsumedQueue(queue)
+	log.Printf("consuming %s (%s) with %d worker(s)", queue, pattern, concurrency)
+	return nil
+}
@@ -0,0 +1,308 @@
+// Package worker is the runtime the processing workers share: it consumes
+// job requests from the broker, runs the registered job types with retries,
+// reports their status to the node and serves health, metrics and
+// dead-letter endpoints.
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"events"
+	"platform/mq"
+)
+
+// JobFunc processes one job. A returned error is retried according to the
+// job's RetryPolicy unless it is wrapped with Permanent.
+type JobFunc func(ctx context.Context, job Job) (JobResult, error)
+
+// JobSpec registers a job type with the worker runtime. Adding a processor
+// is a matter of writing its JobFunc and registering a spec for it.
+type JobSpec struct {
+	// Type names the job in pipelines, status reports and metrics, e.g.
+	// "thumbnail".
+	Type string
+	// Queue receives the node's requests for this job type.
+	Queue string
+
+	Concurrency int
+	Prefetch    int
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+	Retry   RetryPolicy
+
+	Handle JobFunc
+}
+
+// Job is one pipeline step the node asked this worker to run on a video.
+type Job struct {
+	Type     string
+	VideoID  string
+	Pipeline string
+	Bucket   string
+	Object   string
+	// Run is the node's request number for this job; a reprocessed job
+	// comes back with a higher one.
+	Run int
+	// Attempt counts from 1 and grows with every retry.
+	Attempt int
+	// Params are the job-specific options the node sent, if any.
+	Params map[string]string
+	Event  events.Envelope
+}
+
+// JobResult describes what a successful job produced. It is logged and sent
+// to the node with the ready status.
+type JobResult struct {
+	// Outputs are the object keys or prefixes the job wrote.
+	Outputs []string               `json:"outputs,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Config is what the runtime needs from the worker's own configuration.
+type Config struct {
+	Broker mq.Broker
+	// NodeAPIURL receives status callbacks, authenticated with WorkerToken.
+	NodeAPIURL  string
+	WorkerToken string
+	// Bucket is used for requests that do not name one.
+	Bucket string
+}
+
+var (
+	broker        mq.Broker
+	nodeURL       string
+	defaultBucket string
+)
+
+// Init sets up the runtime; it must be called before anything else here.
+func Init(cfg Config) {
+	broker = cfg.Broker
+	nodeURL = cfg.NodeAPIURL
+	workerToken = cfg.WorkerToken
+	defaultBucket = cfg.Bucket
+}
+
+var (
+	jobsMu   sync.Mutex
+	jobSpecs []JobSpec
+)
+
+// RegisterJob adds a job type; it must be called before StartJobs.
+func RegisterJob(spec JobSpec) error {
+	if spec.Type == "" || spec.Queue == "" || spec.Handle == nil {
+		return fmt.Errorf("job spec needs a type, queue and handler")
+	}
+	if spec.Concurrency <= 0 {
+		spec.Concurrency = 1
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	for _, s := range jobSpecs {
+		if s.Type == spec.Type {
+			return fmt.Errorf("job type %q registered twice", spec.Type)
+		}
+		if s.Queue == spec.Queue {
+			return fmt.Errorf("queue %q already consumed by %q jobs", spec.Queue, s.Type)
+		}
+	}
+	jobSpecs = append(jobSpecs, spec)
+	return nil
+}
+
+// StartJobs subscribes every registered job to its queue, and listens for
+// cancellations of running jobs. Handlers run on jobCtx, so cancelling it
+// aborts them and requeues their messages.
+func StartJobs(jobCtx context.Context) error {
+	jobsMu.Lock()
+	specs := append([]JobSpec(nil), jobSpecs...)
+	jobsMu.Unlock()
+
+	for _, spec := range specs {
+		spec := spec
+		jobMetrics.declare(spec.Type)
+		err := ConsumeQueue(jobCtx, events.JobRoutingKey(events.TypeJobRequested, spec.Type), spec.Queue, spec.Concurrency, spec.Prefetch, spec.Retry, func(d mq.Delivery) error {
+			return runJob(jobCtx, spec, d)
+		})
+		if err != nil {
+			return fmt.Errorf("%s jobs: %w", spec.Type, err)
+		}
+	}
+	return subscribeCancellations()
+}
+
+func runJob(jobCtx context.Context, spec JobSpec, d mq.Delivery) error {
+	env, msg, err := events.DecodeJobRequested(d.Message().Body)
+	if err == nil && (msg.Job != spec.Type || msg.VideoID == "") {
+		err = fmt.Errorf("request for %q job on video %q", msg.Job, msg.VideoID)
+	}
+	if err != nil {
+		jobMetrics.finish(spec.Type, outcomeRejected, 0)
+		return Permanent(err)
+	}
+
+	job := Job{
+		Type:     spec.Type,
+		VideoID:  msg.VideoID,
+		Pipeline: msg.Pipeline,
+		Bucket:   msg.Bucket,
+		Object:   msg.Object,
+		Run:      msg.Run,
+		Attempt:  d.Attempts() + 1,
+		Params:   msg.Params,
+		Event:    env,
+	}
+	if job.Bucket == "" {
+		job.Bucket = defaultBucket
+	}
+
+	ctx, cancelJob := context.WithCancelCause(events.WithTraceParent(jobCtx, env.TraceParent))
+	defer cancelJob(nil)
+	defer trackRunning(job, cancelJob)()
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		defer cancel()
+	}
+
+	jobMetrics.start(spec.Type)
+	started := time.Now()
+	result, err := trackJob(ctx, cancelJob, nodeURL, spec, job)
+	elapsed := time.Since(started)
+
+	switch {
+	case errors.Is(context.Cause(ctx), errJobCanceled):
+		// The node has moved on; there is nothing to retry or report.
+		jobMetrics.finish(spec.Type, outcomeCanceled, elapsed)
+		log.Printf("%s: video %s run %d canceled after %s", spec.Type, job.VideoID, job.Run, elapsed.Round(time.Millisecond))
+		return nil
+	case err == nil:
+		jobMetrics.finish(spec.Type, outcomeSucceeded, elapsed)
+		out, _ := json.Marshal(result)
+		log.Printf("%s: video %s done in %s (attempt %d): %s", spec.Type, job.VideoID, elapsed.Round(time.Millisecond), job.Attempt, out)
+	case jobCtx.Err() != nil:
+		jobMetrics.finish(spec.Type, outcomeAborted, elapsed)
+	default:
+		jobMetrics.finish(spec.Type, outcomeFailed, elapsed)
+	}
+	return err
+}
+
+// trackJob reports processing, runs the handler, then reports ready or
+// failed. A failure to report is logged rather than failing the job itself,
+// but a run the node has superseded is stopped with abort.
+func trackJob(ctx context.Context, abort context.CancelCauseFunc, nodeURL string, spec JobSpec, job Job) (JobResult, error) {
+	err := ReportJobStatus(ctx, nodeURL, job.VideoID, spec.Type, JobReport{Status: JobProcessing, Run: job.Run})
+	switch {
+	case errors.Is(err, ErrJobSuperseded):
+		abort(errJobCanceled)
+		return JobResult{}, err
+	case err != nil:
+		log.Printf("report %s processing for %s: %v", spec.Type, job.VideoID, err)
+	}
+
+	progressCtx, cancelProgress := context.WithCancel(ctx)
+	progress := &progressReporter{ctx: progressCtx, cancel: cancelProgress, abort: abort, nodeURL: nodeURL, videoID: job.VideoID, jobType: spec.Type, run: job.Run}
+	result, jobErr := spec.Handle(context.WithValue(ctx, progressKey{}, progress), job)
+	// A late progress report must not overwrite the final status.
+	progress.stop()
+
+	// Report with a fresh context: the handler may have failed because ctx
+	// expired.
+	reportCtx, cancel := context.WithTimeout(events.WithTraceParent(context.Background(), events.TraceParentFrom(ctx)), 30*time.Second)
+	defer cancel()
+
+	report := JobReport{Status: JobReady, Run: job.Run, Progress: 1, Result: &result}
+	switch {
+	case errors.Is(context.Cause(ctx), errJobCanceled):
+		return result, jobErr
+	case jobErr != nil && errors.Is(ctx.Err(), context.Canceled):
+		// Aborted by shutdown; the message is requeued and will run again.
+		report = JobReport{Status: JobQueued, Run: job.Run}
+	case jobErr != nil && !spec.Retry.exhausted(job.Attempt, jobErr):
+		// handleFailure retries it; the video has not failed yet.
+		report = JobReport{Status: JobQueued, Run: job.Run, Error: fmt.Sprintf("attempt %d failed, retrying: %v", job.Attempt, jobErr)}
+	case jobErr != nil:
+		report = JobReport{Status: JobFailed, Run: job.Run, Error: jobErr.Error()}
+	}
+	if err := ReportJobStatus(reportCtx, nodeURL, job.VideoID, spec.Type, report); err != nil {
+		log.Printf("report %s %s for %s: %v", spec.Type, report.Status, job.VideoID, err)
+	}
+	return result, jobErr
+}
+
+// progressInterval throttles progress reports; each one is a replicated
+// write on the node.
+const progressInterval = 5 * time.Second
+
+type progressKey struct{}
+
+type progressReporter struct {
+	ctx                       context.Context
+	cancel                    context.CancelFunc
+	abort                     context.CancelCauseFunc
+	nodeURL, videoID, jobType string
+	run                       int
+
+	mu       sync.Mutex
+	sentAt   time.Time
+	sent     float64
+	inFlight sync.WaitGroup
+	busy     bool
+	stopped  bool
+}
+
+// ReportProgress records how far the job running on ctx has got, as a
+// fraction between 0 and 1. Reports are throttled and sent in the
+// background; outside a tracked job it does nothing.
+func ReportProgress(ctx context.Context, fraction float64) {
+	if p, ok := ctx.Value(progressKey{}).(*progressReporter); ok {
+		p.report(fraction)
+	}
+}
+
+func (p *progressReporter) report(fraction float64) {
+	if fraction < 0 {
+		fraction = 0
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped || p.busy || fraction <= p.sent || time.Since(p.sentAt) < progressInterval {
+		return
+	}
+	p.busy, p.sent, p.sentAt = true, fraction, time.Now()
+
+	p.inFlight.Add(1)
+	go func() {
+		defer p.inFlight.Done()
+		err := ReportJobStatus(p.ctx, p.nodeURL, p.videoID, p.jobType, JobReport{Status: JobProcessing, Run: p.run, Progress: fraction})
+		if errors.Is(err, ErrJobSuperseded) {
+			// The cancel event may have been missed; stop anyway.
+			p.abort(errJobCanceled)
+		} else if err != nil {
+			log.Printf("report %s progress for %s: %v", p.jobType, p.videoID, err)
+		}
+		p.mu.Lock()
+		p.busy = false
+		p.mu.Unlock()
+	}()
+}
+
+// stop drops further reports and abandons one still being sent.
+func (p *progressReporter) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	p.mu.Unlock()
+	p.cancel()
+	p.inFlight.Wait()
+}
@@ -0,0 +1,105 @@
+package worker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job outcomes as counted in worker_jobs_total.
+const (
+	outcomeSucceeded = "succeeded"
+	outcomeFailed    = "failed"
+	outcomeAborted   = "aborted"
+	// outcomeCanceled is a job the node canceled or superseded.
+	outcomeCanceled = "canceled"
+	// outcomeRejected is a message that could not be decoded into a job.
+	outcomeRejected = "rejected"
+)
+
+type jobStats struct {
+	inFlight int
+	outcomes map[string]int
+	seconds  float64
+	count    int
+}
+
+// jobMetrics counts jobs by type. It is small enough to keep by hand rather
+// than pull in a metrics library.
+var jobMetrics = &jobCounters{stats: map[string]*jobStats{}}
+
+type jobCounters struct {
+	mu    sync.Mutex
+	stats map[string]*jobStats
+}
+
+func (m *jobCounters) get(jobType string) *jobStats {
+	s, ok := m.stats[jobType]
+	if !ok {
+		s = &jobStats{outcomes: map[string]int{}}
+		m.stats[jobType] = s
+	}
+	return s
+}
+
+// declare makes jobType show up in the output before its first job.
+func (m *jobCounters) declare(jobType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(jobType)
+}
+
+func (m *jobCounters) start(jobType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.get(jobType).inFlight++
+}
+
+// finish records a job's outcome. Rejected messages never started, so they
+// do not touch the in-flight gauge or durations.
+func (m *jobCounters) finish(jobType, outcome string, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(jobType)
+	s.outcomes[outcome]++
+	if outcome == outcomeRejected {
+		return
+	}
+	s.inFlight--
+	s.seconds += elapsed.Seconds()
+	s.count++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *jobCounters) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	types := make([]string, 0, len(m.stats))
+	for t := range m.stats {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP worker_jobs_total Jobs finished, by type and outcome.")
+	fmt.Fprintln(w, "# TYPE worker_jobs_total counter")
+	for _, t := range types {
+		for _, outcome := range []string{outcomeSucceeded, outcomeFailed, outcomeAborted, outcomeCanceled, outcomeRejected} {
+			fmt.Fprintf(w, "worker_jobs_total{type=%q,outcome=%q} %d\n", t, outcome, m.stats[t].outcomes[outcome])
+		}
+	}
+	fmt.Fprintln(w, "# HELP worker_jobs_in_flight Jobs currently running, by type.")
+	fmt.Fprintln(w, "# TYPE worker_jobs_in_flight gauge")
+	for _, t := range types {
+		fmt.Fprintf(w, "worker_jobs_in_flight{type=%q} %d\n", t, m.stats[t].inFlight)
+	}
+	fmt.Fprintln(w, "# HELP worker_job_duration_seconds Time spent running jobs, by type.")
+	fmt.Fprintln(w, "# TYPE worker_job_duration_seconds summary")
+	for _, t := range types {
+		fmt.Fprintf(w, "worker_job_duration_seconds_sum{type=%q} %g\n", t, m.stats[t].seconds)
+		fmt.Fprintf(w, "worker_job_duration_seconds_count{type=%q} %d\n", t, m.stats[t].count)
+	}
+}
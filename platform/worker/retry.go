@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"platform/mq"
+)
+
+// RetryPolicy bounds how often a failing message is retried before it is
+// dead-lettered. Delays grow exponentially from BaseDelay up to MaxDelay.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// exhausted reports whether a failure on attempt is final, because err is
+// Permanent or no attempts are left; the delivery is then dead-lettered.
+func (p RetryPolicy) exhausted(attempt int, err error) bool {
+	return IsPermanent(err) || attempt >= p.MaxAttempts
+}
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying (e.g. an undecodable message);
+// the delivery goes straight to the dead-letter queue.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, was marked Permanent.
+func IsPermanent(err error) bool {
+	var perm permanentError
+	return errors.As(err, &perm)
+}
+
+// handleFailure decides what happens to a delivery whose handler failed:
+// schedule a delayed retry, or dead-letter it once attempts are exhausted.
+func handleFailure(queue string, policy RetryPolicy, d mq.Delivery, handlerErr error) {
+	attempt := d.Attempts() + 1
+	if policy.exhausted(attempt, handlerErr) {
+		if err := d.DeadLetter(handlerErr); err != nil {
+			log.Printf("%s: failed to dead-letter message, requeueing: %v", queue, err)
+			return
+		}
+		log.Printf("%s: dead-lettered message after %d attempt(s): %v", queue, attempt, handlerErr)
+		return
+	}
+
+	delay := policy.delay(attempt)
+	if err := d.Retry(delay, handlerErr); err != nil {
+		log.Printf("%s: retry failed, requeueing: %v", queue, err)
+		return
+	}
+	log.Printf("%s: attempt %d/%d failed, retrying in %s: %v", queue, attempt, policy.MaxAttempts, delay, handlerErr)
+}
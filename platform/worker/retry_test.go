@@ -0,0 +1,109 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"platform/mq"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	for attempt, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		50: 10 * time.Second,
+	} {
+		if got := p.delay(attempt); got != want {
+			t.Errorf("delay(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+
+	failed := errors.New("failed")
+	if p.exhausted(4, failed) || !p.exhausted(5, failed) {
+		t.Error("want attempts exhausted on the fifth failure, not before")
+	}
+	if !p.exhausted(1, Permanent(failed)) {
+		t.Error("want a permanent error to exhaust attempts at once")
+	}
+}
+
+// consumeFailing consumes a test queue whose handler always fails with err,
+// publishes one message to it and waits until it is dead-lettered. It returns
+// the attempt count seen by each handler call, when it was made, and the
+// dead letter.
+func consumeFailing(t *testing.T, policy RetryPolicy, err error) ([]int, []time.Time, mq.DeadLetter) {
+	t.Helper()
+	mem := mq.NewMemoryBroker()
+	broker = mem
+	t.Cleanup(func() { mem.Close() })
+
+	var (
+		mu       sync.Mutex
+		attempts []int
+		times    []time.Time
+	)
+	handler := func(d mq.Delivery) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, d.Attempts())
+		times = append(times, time.Now())
+		return err
+	}
+	if err := ConsumeQueue(context.Background(), "test.retry", "test.retry", 1, 1, policy, handler); err != nil {
+		t.Fatal(err)
+	}
+	if err := mem.Publish(context.Background(), "test.retry", mq.Message{ID: "m1", Body: []byte(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		letters, _ := mem.DeadLetters("test.retry", 10)
+		if len(letters) > 0 {
+			mu.Lock()
+			defer mu.Unlock()
+			return attempts, times, letters[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("message was never dead-lettered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestConsumeQueueRetriesThenDeadLetters(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond, MaxDelay: 30 * time.Millisecond}
+	attempts, times, letter := consumeFailing(t, policy, errors.New("transient"))
+
+	if len(attempts) != 3 || attempts[0] != 0 || attempts[1] != 1 || attempts[2] != 2 {
+		t.Fatalf("handler saw attempts %v, want [0 1 2]", attempts)
+	}
+	// Retries go through the delay queue, so they wait for their backoff.
+	for i, want := range []time.Duration{20 * time.Millisecond, 30 * time.Millisecond} {
+		if got := times[i+1].Sub(times[i]); got < want {
+			t.Errorf("retry %d came after %s, want at least %s", i+1, got, want)
+		}
+	}
+	if letter.ID != "m1" || letter.Attempts != 3 || letter.LastError != "transient" {
+		t.Errorf("dead letter = %+v, want m1 after 3 attempts", letter)
+	}
+}
+
+func TestConsumeQueueDeadLettersPermanentErrors(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	attempts, _, letter := consumeFailing(t, policy, Permanent(errors.New("undecodable")))
+
+	if len(attempts) != 1 {
+		t.Errorf("handler ran %d times, want once", len(attempts))
+	}
+	if letter.Attempts != 1 || letter.LastError != "undecodable" {
+		t.Errorf("dead letter = %+v, want undecodable after 1 attempt", letter)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "platform/mq"
+
+var broker mq.Broker
+
+func InitBroker(cfg Config) error {
+	b, err := mq.Open(cfg.BrokerBackend, cfg.RabbitURL, cfg.BrokerExchange)
+	if err != nil {
+		return err
+	}
+	broker = b
+	return nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"context"
+
+	"platform/worker"
+)
+
+// ReportThumbnails tells the leader which thumbnail variants were rendered,
+// replacing any it had recorded before.
+func ReportThumbnails(ctx context.Context, nodeURL, videoID string, thumbs []Thumbnail) error {
+	return worker.PostCallback(ctx, nodeURL, videoID, "thumbnails", map[string][]Thumbnail{"thumbnails": thumbs})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"platform/worker"
+)
+
+const (
+	// maxSceneCandidates and uniformCandidates bound how many frames are
+	// scored: scene changes first, topped up with evenly spaced samples.
+	maxSceneCandidates = 6
+	uniformCandidates  = 6
+	// sceneThreshold is ffmpeg's scene score above which a frame starts a
+	// new shot.
+	sceneThreshold = 0.3
+	// sceneScanSeconds caps how much of a long video is scanned for cuts.
+	sceneScanSeconds = 600
+	// sceneOffset skips past the cut itself, which is often mid-transition.
+	sceneOffset = 0.5
+	// candidateWidth is the size candidates are scored at.
+	candidateWidth = 320
+)
+
+// frameScore is how a candidate frame rated. Frames that are almost black,
+// white or flat (fades, blank title cards) score zero.
+type frameScore struct {
+	At         float64
+	Brightness float64
+	Sharpness  float64
+	Score      float64
+}
+
+// pickFrame chooses a representative frame time for inPath by scoring frames
+// at scene changes and at evenly spaced points. Videos too short to sample
+// use their middle frame.
+func pickFrame(ctx context.Context, inPath, workDir string) (frameScore, int, error) {
+	duration, err := probeDuration(ctx, inPath)
+	if err != nil {
+		log.Printf("thumbnail: %v; using the first frame", err)
+	}
+
+	var scenes []float64
+	if duration >= 1 {
+		scenes, err = sceneChanges(ctx, inPath)
+		if err != nil {
+			// Not fatal: the uniform samples still give a decent pick.
+			log.Printf("thumbnail: scene detection: %v", err)
+		}
+	}
+	times := candidateTimes(duration, scenes)
+
+	var best frameScore
+	scored := 0
+	for i, at := range times {
+		path := filepath.Join(workDir, fmt.Sprintf("candidate-%02d.jpg", i))
+		if err := extractFrame(ctx, inPath, path, at, candidateWidth); err != nil {
+			if ctx.Err() != nil {
+				return frameScore{}, scored, ctx.Err()
+			}
+			continue
+		}
+		s, err := scoreFrame(path)
+		os.Remove(path)
+		if err != nil {
+			continue
+		}
+		s.At = at
+		scored++
+		if scored == 1 || s.Score > best.Score {
+			best = s
+		}
+		worker.ReportProgress(ctx, 0.8*float64(i+1)/float64(len(times)))
+	}
+	if scored == 0 {
+		return frameScore{At: math.Min(1, duration/2)}, 0, nil
+	}
+	return best, scored, nil
+}
+
+// candidateTimes merges scene changes with evenly spaced samples between 5%
+// and 95% of the video, keeping them at least a second apart. Clips under a
+// second only have their middle frame.
+func candidateTimes(duration float64, scenes []float64) []float64 {
+	if duration < 1 {
+		return []float64{duration / 2}
+	}
+
+	var times []float64
+	if len(scenes) > maxSceneCandidates {
+		step := float64(len(scenes)) / maxSceneCandidates
+		picked := make([]float64, 0, maxSceneCandidates)
+		for i := 0; i < maxSceneCandidates; i++ {
+			picked = append(picked, scenes[int(float64(i)*step)])
+		}
+		scenes = picked
+	}
+	for _, at := range scenes {
+		if at += sceneOffset; at < duration {
+			times = append(times, at)
+		}
+	}
+	for i := 0; i < uniformCandidates; i++ {
+		times = append(times, duration*(0.05+0.9*float64(i)/float64(uniformCandidates-1)))
+	}
+
+	sort.Float64s(times)
+	out := times[:0]
+	for _, at := range times {
+		if len(out) == 0 || at-out[len(out)-1] >= 1 {
+			out = append(out, at)
+		}
+	}
+	return out
+}
+
+var ptsTimePattern = regexp.MustCompile(`pts_time:\s*([0-9.]+)`)
+
+// sceneChanges lists the times of shot changes, looking at keyframes only so
+// that long videos are not fully decoded.
+func sceneChanges(ctx context.Context, inPath string) ([]float64, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-hide_banner",
+		"-skip_frame", "nokey",
+		"-t", strconv.Itoa(sceneScanSeconds),
+		"-i", inPath,
+		"-an",
+		"-vf", fmt.Sprintf("select='gt(scene,%g)',showinfo", sceneThreshold),
+		"-f", "null", "-",
+	)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %w", err)
+	}
+
+	var times []float64
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		if !strings.Contains(line, "Parsed_showinfo") {
+			continue
+		}
+		if m := ptsTimePattern.FindStringSubmatch(line); m != nil {
+			if at, err := strconv.ParseFloat(m[1], 64); err == nil {
+				times = append(times, at)
+			}
+		}
+	}
+	return times, nil
+}
+
+func probeDuration(ctx context.Context, inPath string) (float64, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		inPath,
+	).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe: %w", err)
+	}
+	d, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe duration %q: %w", strings.TrimSpace(string(out)), err)
+	}
+	return d, nil
+}
+
+// scoreFrame rates the image at path by sharpness (variance of the
+// Laplacian of its luma), weighted towards mid-range brightness.
+func scoreFrame(path string) (frameScore, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return frameScore{}, err
+	}
+	defer fh.Close()
+	img, _, err := image.Decode(fh)
+	if err != nil {
+		return frameScore{}, err
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 3 || h < 3 {
+		return frameScore{}, fmt.Errorf("frame is %dx%d", w, h)
+	}
+	luma := make([]float64, w*h)
+	var sum, sumSq float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			l := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)) / 257
+			luma[y*w+x] = l
+			sum += l
+			sumSq += l * l
+		}
+	}
+	n := float64(w * h)
+	mean := sum / n
+	stddev := math.Sqrt(math.Max(0, sumSq/n-mean*mean))
+
+	var lapSum, lapSumSq float64
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			lap := luma[i-w] + luma[i+w] + luma[i-1] + luma[i+1] - 4*luma[i]
+			lapSum += lap
+			lapSumSq += lap * lap
+		}
+	}
+	m := float64((w - 2) * (h - 2))
+	lapMean := lapSum / m
+	sharpness := lapSumSq/m - lapMean*lapMean
+
+	s := frameScore{Brightness: mean, Sharpness: sharpness}
+	if mean < 20 || mean > 235 || stddev < 10 {
+		return s, nil
+	}
+	exposure := 1 - math.Abs(mean-128)/128
+	s.Score = sharpness * (0.25 + 0.75*exposure)
+	return s, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCandidateTimesShortClip(t *testing.T) {
+	for _, duration := range []float64{0, 0.4, 0.99} {
+		times := candidateTimes(duration, []float64{0.1})
+		if len(times) != 1 || times[0] != duration/2 {
+			t.Errorf("candidateTimes(%g) = %v, want [%g]", duration, times, duration/2)
+		}
+	}
+}
+
+func TestCandidateTimesMergesScenesAndUniformSamples(t *testing.T) {
+	// Cuts at 10 and 10.2 are too close to keep both, and one past the end
+	// of the video is dropped.
+	times := candidateTimes(100, []float64{10, 10.2, 50, 99.8})
+	want := []float64{5, 10.5, 23, 41, 50.5, 59, 77, 95}
+	if len(times) != len(want) {
+		t.Fatalf("candidateTimes = %v, want %v", times, want)
+	}
+	for i := range want {
+		if math.Abs(times[i]-want[i]) > 1e-9 {
+			t.Fatalf("candidateTimes = %v, want %v", times, want)
+		}
+	}
+
+	// Many cuts are thinned out to maxSceneCandidates.
+	var scenes []float64
+	for at := 2.0; at < 98; at += 2 {
+		scenes = append(scenes, at)
+	}
+	times = candidateTimes(100, scenes)
+	if limit := maxSceneCandidates + uniformCandidates; len(times) > limit {
+		t.Errorf("got %d candidates, want at most %d", len(times), limit)
+	}
+	for i := 1; i < len(times); i++ {
+		if times[i]-times[i-1] < 1 {
+			t.Errorf("candidates %g and %g are less than a second apart", times[i-1], times[i])
+		}
+	}
+}
+
+// writeFrame renders a 64x64 frame whose luma at (x, y) is luma(x, y) and
+// returns its path.
+func writeFrame(t *testing.T, name string, luma func(x, y int) float64) string {
+	t.Helper()
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8(math.Round(luma(x, y)))})
+		}
+	}
+	path := filepath.Join(t.TempDir(), name+".png")
+	fh, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	if err := png.Encode(fh, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScoreFramePrefersSharpFrames(t *testing.T) {
+	frames := []struct {
+		name string
+		luma func(x, y int) float64
+	}{
+		{"checkerboard", func(x, y int) float64 {
+			if (x/8+y/8)%2 == 0 {
+				return 64
+			}
+			return 192
+		}},
+		{"soft", func(x, y int) float64 {
+			return 128 + 64*math.Sin(2*math.Pi*float64(x)/16)*math.Sin(2*math.Pi*float64(y)/16)
+		}},
+		{"gradient", func(x, y int) float64 { return 64 + 2*float64(x) }},
+	}
+
+	var previous frameScore
+	for i, f := range frames {
+		s, err := scoreFrame(writeFrame(t, f.name, f.luma))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Score <= 0 {
+			t.Errorf("%s scored %g, want it above zero", f.name, s.Score)
+		}
+		if i > 0 && s.Score >= previous.Score {
+			t.Errorf("%s scored %g, want less than %s's %g", f.name, s.Score, frames[i-1].name, previous.Score)
+		}
+		previous = s
+	}
+
+	for name, luma := range map[string]float64{"black": 5, "flat": 128, "white": 250} {
+		s, err := scoreFrame(writeFrame(t, name, func(x, y int) float64 { return luma }))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Score != 0 {
+			t.Errorf("%s frame scored %g, want 0", name, s.Score)
+		}
+	}
+}
@@ -2,82 +2,76 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"log"
-	"os"
 	"os/signal"
-	"path/filepath"
-	"strings"
 	"syscall"
 	"time"
-)
-
-type VideoUploaded struct {
-	Bucket string `json:"bucket"`
-	Object string `json:"object"`
-}
 
-func loadConfig() Config {
-	cfg := Config{
-		RabbitURL:      os.Getenv("RABBIT_URL"),
-		MinIOEndpoint:  os.Getenv("MINIO_ENDPOINT"),
-		MinIOAccessKey: os.Getenv("MINIO_ACCESS_KEY"),
-		MinIOSecretKey: os.Getenv("MINIO_SECRET_KEY"),
-		MinIOBucket:    os.Getenv("MINIO_BUCKET"),
-	}
-	if cfg.MinIOBucket == "" {
-		cfg.MinIOBucket = "videos"
-	}
-	return cfg
-}
+	"platform/worker"
+)
 
 func main() {
-	cfg := loadConfig()
+	cfg := LoadConfig()
 
-	if err := InitMinIO(cfg); err != nil {
-		log.Fatalf("init MinIO: %v", err)
+	if err := InitStorage(cfg); err != nil {
+		log.Fatalf("init %s storage: %v", cfg.StorageBackend, err)
 	}
-	if err := InitRabbit(cfg); err != nil {
-		log.Fatalf("init RabbitMQ: %v", err)
+	if err := InitBroker(cfg); err != nil {
+		log.Fatalf("init %s broker: %v", cfg.BrokerBackend, err)
 	}
-	log.Println("worker-thumbnail: connected to RabbitMQ & MinIO")
+	log.Println("worker-thumbnail: connected to broker & object storage")
+	worker.Init(worker.Config{
+		Broker:      broker,
+		NodeAPIURL:  cfg.NodeAPIURL,
+		WorkerToken: cfg.WorkerToken,
+		Bucket:      cfg.MinIOBucket,
+	})
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	err := ConsumeQueue("video_uploaded", func(body []byte) error {
-		var msg VideoUploaded
-		if err := json.Unmarshal(body, &msg); err != nil {
-			return err
-		}
-
-		bucket := msg.Bucket
-		if bucket == "" {
-			bucket = cfg.MinIOBucket
-		}
+	// Jobs run on their own context so a shutdown signal lets them finish;
+	// it is only cancelled, killing ffmpeg, once the drain deadline passes.
+	jobCtx, abortJobs := context.WithCancel(context.Background())
+	defer abortJobs()
 
-		
-		base := filepath.Base(msg.Object)
-		base = strings.TrimSuffix(base, filepath.Ext(base))
-		thumbKey := "thumbnails/" + base + ".jpg"
+	worker.StartAdminServer(cfg.AdminPort)
 
-		log.Printf("creating thumbnail for s3://%s/%s -> %s", bucket, msg.Object, thumbKey)
-
-		ctxTimeout, cancel := context.WithTimeout(ctx, 2*time.Minute)
-		defer cancel()
-
-		if err := CreateAndUploadThumbnail(ctxTimeout, bucket, msg.Object, thumbKey, 1); err != nil {
-			return err
-		}
-
-		log.Printf("thumbnail uploaded: s3://%s/%s", bucket, thumbKey)
-		return nil
+	err := worker.RegisterJob(worker.JobSpec{
+		Type:        "thumbnail",
+		Queue:       "jobs.thumbnail",
+		Concurrency: cfg.Concurrency,
+		Prefetch:    cfg.Prefetch,
+		Timeout:     2 * time.Minute,
+		Retry:       cfg.Retry,
+		Handle:      thumbnailJob(cfg),
+	})
+	if err != nil {
+		log.Fatalf("register jobs: %v", err)
+	}
+	// Storyboards decode the whole video, so run one at a time.
+	err = worker.RegisterJob(worker.JobSpec{
+		Type:        "storyboard",
+		Queue:       "jobs.storyboard",
+		Concurrency: 1,
+		Prefetch:    1,
+		Timeout:     15 * time.Minute,
+		Retry:       cfg.Retry,
+		Handle:      storyboardJob(cfg),
 	})
 	if err != nil {
+		log.Fatalf("register jobs: %v", err)
+	}
+	if err := worker.StartJobs(jobCtx); err != nil {
 		log.Fatalf("consume: %v", err)
 	}
 
 	<-ctx.Done()
-	log.Println("worker-thumbnail: shutting down")
-	CloseRabbit()
+	log.Printf("worker-thumbnail: shutting down, waiting up to %s for in-flight jobs", cfg.ShutdownTimeout)
+	if !broker.Drain(cfg.ShutdownTimeout) {
+		log.Println("worker-thumbnail: drain deadline passed, aborting remaining jobs")
+		abortJobs()
+		broker.Drain(10 * time.Second)
+	}
+	_ = broker.Close()
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	"platform/storage"
+)
+
+var objectStore storage.ObjectStore
+
+func InitStorage(cfg Config) error {
+	store, err := storage.Open(storage.Config{
+		Backend:        cfg.StorageBackend,
+		MinIOEndpoint:  cfg.MinIOEndpoint,
+		MinIOAccessKey: cfg.MinIOAccessKey,
+		MinIOSecretKey: cfg.MinIOSecretKey,
+		LocalPath:      cfg.LocalStoragePath,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := store.EnsureBucket(context.Background(), cfg.MinIOBucket); err != nil {
+		return err
+	}
+	objectStore = store
+	return nil
+}
+
+// deletePrefix removes every object under prefix.
+func deletePrefix(ctx context.Context, bucket, prefix string) error {
+	objects, err := objectStore.List(ctx, bucket, prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := objectStore.Delete(ctx, bucket, obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
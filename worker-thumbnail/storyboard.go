@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"platform/worker"
+)
+
+// Each sprite sheet is a grid of storyboardColumns x storyboardRows frames.
+const (
+	storyboardColumns = 5
+	storyboardRows    = 5
+	// maxStoryboardFrames bounds the sheets for long videos by widening the
+	// interval.
+	maxStoryboardFrames = 1000
+)
+
+// StoryboardPrefix holds a video's sprite sheets and the WebVTT track that
+// indexes them; it must match the node's.
+func StoryboardPrefix(videoID string) string {
+	return "derived/" + videoID + "/storyboard/"
+}
+
+// storyboard describes what CreateStoryboard wrote.
+type storyboard struct {
+	Sheets   int
+	Frames   int
+	Interval float64
+	TileW    int
+	TileH    int
+}
+
+// storyboardJob renders seek-bar preview sprites and their WebVTT track.
+func storyboardJob(cfg Config) worker.JobFunc {
+	return func(ctx context.Context, job worker.Job) (worker.JobResult, error) {
+		prefix := StoryboardPrefix(job.VideoID)
+		log.Printf("creating storyboard for video %s (s3://%s/%s) -> %s", job.VideoID, job.Bucket, job.Object, prefix)
+		sb, err := CreateStoryboard(ctx, job.Bucket, job.Object, prefix, cfg.StoryboardInterval, cfg.StoryboardTileWidth)
+		if err != nil {
+			return worker.JobResult{}, err
+		}
+		return worker.JobResult{
+			Outputs: []string{prefix},
+			Details: map[string]interface{}{
+				"sheets":           sb.Sheets,
+				"frames":           sb.Frames,
+				"interval_seconds": sb.Interval,
+				"tile":             fmt.Sprintf("%dx%d", sb.TileW, sb.TileH),
+			},
+		}, nil
+	}
+}
+
+// CreateStoryboard samples a frame every interval, tiles the frames into
+// sprite sheets of tileWidth-wide tiles and uploads them with storyboard.vtt,
+// whose cues point at each tile with a #xywh fragment. The track is uploaded
+// last so it never refers to sheets that are not there yet.
+func CreateStoryboard(ctx context.Context, bucket, srcKey, prefix string, interval time.Duration, tileWidth int) (storyboard, error) {
+	inPath, err := downloadObject(ctx, bucket, srcKey)
+	if err != nil {
+		return storyboard{}, err
+	}
+	defer os.Remove(inPath)
+
+	duration, err := probeDuration(ctx, inPath)
+	if err != nil {
+		return storyboard{}, err
+	}
+	if duration <= 0 {
+		return storyboard{}, worker.Permanent(fmt.Errorf("video has no duration"))
+	}
+	step := interval.Seconds()
+	if step <= 0 {
+		return storyboard{}, worker.Permanent(fmt.Errorf("storyboard interval %s is not positive", interval))
+	}
+	if duration/step > maxStoryboardFrames {
+		step = duration / maxStoryboardFrames
+	}
+
+	workDir, err := os.MkdirTemp("", "storyboard-*")
+	if err != nil {
+		return storyboard{}, err
+	}
+	defer os.RemoveAll(workDir)
+
+	out, err := exec.CommandContext(ctx, "ffmpeg",
+		"-y",
+		"-i", inPath,
+		"-an",
+		"-vf", fmt.Sprintf("fps=1/%g,scale=%d:-2,tile=%dx%d", step, tileWidth, storyboardColumns, storyboardRows),
+		"-q:v", "5",
+		"-start_number", "0",
+		filepath.Join(workDir, "sprite-%03d.jpg"),
+	).CombinedOutput()
+	if err != nil {
+		return storyboard{}, fmt.Errorf("ffmpeg storyboard: %w: %s", err, lastLine(out))
+	}
+	worker.ReportProgress(ctx, 0.8)
+
+	sheets, err := filepath.Glob(filepath.Join(workDir, "sprite-*.jpg"))
+	if err != nil || len(sheets) == 0 {
+		return storyboard{}, fmt.Errorf("ffmpeg storyboard: no sprite sheets written")
+	}
+	sort.Strings(sheets)
+	w, h, err := jpegSize(sheets[0])
+	if err != nil {
+		return storyboard{}, fmt.Errorf("read sprite sheet: %w", err)
+	}
+
+	perSheet := storyboardColumns * storyboardRows
+	sb := storyboard{
+		Sheets:   len(sheets),
+		Frames:   int(math.Ceil(duration / step)),
+		Interval: step,
+		TileW:    w / storyboardColumns,
+		TileH:    h / storyboardRows,
+	}
+	if sb.Frames > sb.Sheets*perSheet {
+		sb.Frames = sb.Sheets * perSheet
+	}
+
+	// A shorter render than the last one would leave its extra sheets
+	// behind.
+	if err := deletePrefix(ctx, bucket, prefix); err != nil {
+		return storyboard{}, err
+	}
+	for _, sheet := range sheets {
+		if _, err := uploadFile(ctx, bucket, prefix+filepath.Base(sheet), sheet, "image/jpeg"); err != nil {
+			return storyboard{}, err
+		}
+	}
+	vttPath := filepath.Join(workDir, "storyboard.vtt")
+	if err := os.WriteFile(vttPath, []byte(storyboardVTT(sb, duration)), 0o644); err != nil {
+		return storyboard{}, err
+	}
+	if _, err := uploadFile(ctx, bucket, prefix+"storyboard.vtt", vttPath, "text/vtt"); err != nil {
+		return storyboard{}, err
+	}
+	return sb, nil
+}
+
+// storyboardVTT writes one cue per frame. Sheet URLs are relative to the
+// track, which the node serves at /videos/{id}/storyboard.vtt with the sheets
+// under /videos/{id}/storyboard/.
+func storyboardVTT(sb storyboard, duration float64) string {
+	perSheet := storyboardColumns * storyboardRows
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; i < sb.Frames; i++ {
+		start := float64(i) * sb.Interval
+		end := math.Min(start+sb.Interval, duration)
+		pos := i % perSheet
+		fmt.Fprintf(&b, "\n%s --> %s\nstoryboard/sprite-%03d.jpg#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), i/perSheet,
+			(pos%storyboardColumns)*sb.TileW, (pos/storyboardColumns)*sb.TileH, sb.TileW, sb.TileH)
+	}
+	return b.String()
+}
+
+// vttTimestamp formats seconds as hh:mm:ss.mmm.
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
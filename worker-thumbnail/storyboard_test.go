@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"platform/storage"
+)
+
+func TestVTTTimestamp(t *testing.T) {
+	for seconds, want := range map[float64]string{
+		0:        "00:00:00.000",
+		5.25:     "00:00:05.250",
+		59.9996:  "00:01:00.000",
+		3599.999: "00:59:59.999",
+		3600:     "01:00:00.000",
+		3725.5:   "01:02:05.500",
+		36000:    "10:00:00.000",
+	} {
+		if got := vttTimestamp(seconds); got != want {
+			t.Errorf("vttTimestamp(%g) = %s, want %s", seconds, got, want)
+		}
+	}
+}
+
+var spriteName = regexp.MustCompile(`^sprite-[0-9]{3,}\.jpg$`)
+
+func TestStoryboardVTT(t *testing.T) {
+	// 27 frames fill one sheet and two tiles of a second.
+	sb := storyboard{Sheets: 2, Frames: 27, Interval: 5, TileW: 160, TileH: 90}
+	vtt := storyboardVTT(sb, 132.4)
+
+	if !strings.HasPrefix(vtt, "WEBVTT\n\n") {
+		t.Fatalf("track does not start with a WEBVTT header:\n%s", vtt)
+	}
+	cues := strings.Split(strings.TrimSpace(strings.TrimPrefix(vtt, "WEBVTT\n")), "\n\n")
+	if len(cues) != sb.Frames {
+		t.Fatalf("got %d cues, want %d", len(cues), sb.Frames)
+	}
+
+	for i, cue := range cues {
+		lines := strings.Split(cue, "\n")
+		if len(lines) != 2 {
+			t.Fatalf("cue %d = %q, want timings and a URL", i, cue)
+		}
+		path, fragment, ok := strings.Cut(lines[1], "#")
+		name := strings.TrimPrefix(path, "storyboard/")
+		if !ok || name == path || !spriteName.MatchString(name) {
+			t.Errorf("cue %d points at %q, want storyboard/sprite-NNN.jpg#xywh=...", i, lines[1])
+		}
+		if want := fmt.Sprintf("sprite-%03d.jpg", i/25); name != want {
+			t.Errorf("cue %d is on %s, want %s", i, name, want)
+		}
+		pos := i % 25
+		if want := fmt.Sprintf("xywh=%d,%d,160,90", pos%5*160, pos/5*90); fragment != want {
+			t.Errorf("cue %d fragment = %s, want %s", i, fragment, want)
+		}
+	}
+
+	if cues[1] != "00:00:05.000 --> 00:00:10.000\nstoryboard/sprite-000.jpg#xywh=160,0,160,90" {
+		t.Errorf("second cue = %q", cues[1])
+	}
+	if cues[24] != "00:02:00.000 --> 00:02:05.000\nstoryboard/sprite-000.jpg#xywh=640,360,160,90" {
+		t.Errorf("last cue of the first sheet = %q", cues[24])
+	}
+	// The last cue ends with the video, not a whole interval after it starts.
+	if cues[26] != "00:02:10.000 --> 00:02:12.400\nstoryboard/sprite-001.jpg#xywh=160,0,160,90" {
+		t.Errorf("last cue = %q", cues[26])
+	}
+}
+
+func TestStoryboardVTTPastAnHour(t *testing.T) {
+	sb := storyboard{Sheets: 1, Frames: 3, Interval: 1800, TileW: 160, TileH: 90}
+	want := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:30:00.000\nstoryboard/sprite-000.jpg#xywh=0,0,160,90\n" +
+		"\n00:30:00.000 --> 01:00:00.000\nstoryboard/sprite-000.jpg#xywh=160,0,160,90\n" +
+		"\n01:00:00.000 --> 01:00:00.500\nstoryboard/sprite-000.jpg#xywh=320,0,160,90\n"
+	if got := storyboardVTT(sb, 3600.5); got != want {
+		t.Errorf("storyboardVTT =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDeletePrefixClearsOldSheets(t *testing.T) {
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectStore = store
+	ctx := context.Background()
+	if err := store.EnsureBucket(ctx, "videos"); err != nil {
+		t.Fatal(err)
+	}
+	prefix := StoryboardPrefix("v1")
+	keys := []string{
+		prefix + "sprite-000.jpg",
+		prefix + "sprite-001.jpg",
+		prefix + "storyboard.vtt",
+		"derived/v1/thumbnails/default.jpg",
+		StoryboardPrefix("v10") + "sprite-000.jpg",
+	}
+	for _, key := range keys {
+		if _, err := store.Put(ctx, "videos", key, strings.NewReader("x"), 1, "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := deletePrefix(ctx, "videos", prefix); err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		_, err := store.Stat(ctx, "videos", key)
+		if gone := errors.Is(err, storage.ErrObjectNotFound); gone != (i < 3) {
+			t.Errorf("%s: stat err = %v, want it deleted only under %s", key, err, prefix)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"platform/worker"
+)
+
+// ThumbnailPrefix is where thumbnails for a video live; it must match the
+// node's DerivedPrefix.
+func ThumbnailPrefix(videoID string) string {
+	return "derived/" + videoID + "/thumbnails/"
+}
+
+// Frame selection methods reported in the job result.
+const (
+	selectedOverride = "override"
+	selectedAuto     = "auto"
+	// selectedFallback is used when no candidate frame could be scored,
+	// e.g. for clips too short to sample.
+	selectedFallback = "fallback"
+)
+
+// thumbnailJob renders a video's thumbnails from the frame at the "timestamp"
+// param if the user set one, or else the best-scoring candidate frame, in
+// every configured size and format, and reports them to the node.
+func thumbnailJob(cfg Config) worker.JobFunc {
+	return func(ctx context.Context, job worker.Job) (worker.JobResult, error) {
+		var at *float64
+		if v, ok := job.Params["timestamp"]; ok {
+			t, err := strconv.ParseFloat(v, 64)
+			if err != nil || t < 0 {
+				return worker.JobResult{}, worker.Permanent(fmt.Errorf("invalid thumbnail timestamp %q", v))
+			}
+			at = &t
+		}
+
+		prefix := ThumbnailPrefix(job.VideoID)
+		log.Printf("creating thumbnails for video %s (s3://%s/%s) -> %s", job.VideoID, job.Bucket, job.Object, prefix)
+		frame, thumbs, err := CreateAndUploadThumbnails(ctx, job.Bucket, job.Object, job.VideoID, at, cfg.ThumbnailSizes, cfg.ThumbnailFormats)
+		if err != nil {
+			return worker.JobResult{}, err
+		}
+		if err := ReportThumbnails(ctx, cfg.NodeAPIURL, job.VideoID, thumbs); err != nil {
+			return worker.JobResult{}, err
+		}
+
+		sizes := []string{defaultSize}
+		for _, size := range cfg.ThumbnailSizes {
+			sizes = append(sizes, size.Name)
+		}
+		formats := make([]string, len(cfg.ThumbnailFormats))
+		for i, f := range cfg.ThumbnailFormats {
+			formats[i] = f.Name
+		}
+		return worker.JobResult{
+			Outputs: []string{prefix},
+			Details: map[string]interface{}{
+				"sizes":      sizes,
+				"formats":    formats,
+				"timestamp":  frame.At,
+				"selection":  frame.Selection,
+				"candidates": frame.Candidates,
+			},
+		}, nil
+	}
+}
+
+// chosenFrame describes the frame thumbnails were rendered from.
+type chosenFrame struct {
+	At        float64
+	Selection string
+	// Candidates is how many frames were scored to pick it.
+	Candidates int
+}
+
+// CreateAndUploadThumbnails renders the frame at *at, or a picked one if at
+// is nil, and uploads it full-size and at each of sizes, in every format.
+func CreateAndUploadThumbnails(ctx context.Context, bucket, srcKey, videoID string, at *float64, sizes []ThumbnailSize, formats []ThumbnailFormat) (chosenFrame, []Thumbnail, error) {
+	inPath, err := downloadObject(ctx, bucket, srcKey)
+	if err != nil {
+		return chosenFrame{}, nil, err
+	}
+	defer os.Remove(inPath)
+
+	workDir, err := os.MkdirTemp("", "thumbs-*")
+	if err != nil {
+		return chosenFrame{}, nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	framePath := filepath.Join(workDir, "default.jpg")
+	var frame chosenFrame
+	if at != nil {
+		frame = chosenFrame{At: *at, Selection: selectedOverride}
+		if err := extractFrame(ctx, inPath, framePath, *at, 0); err != nil {
+			if ctx.Err() != nil {
+				return chosenFrame{}, nil, err
+			}
+			// Most likely past the end of a video the node had no duration
+			// for; pick a frame instead of failing.
+			log.Printf("thumbnail at %gs: %v; picking a frame instead", *at, err)
+			at = nil
+		}
+	}
+	if at == nil {
+		best, scored, err := pickFrame(ctx, inPath, workDir)
+		if err != nil {
+			return chosenFrame{}, nil, err
+		}
+		frame = chosenFrame{At: best.At, Selection: selectedAuto, Candidates: scored}
+		if scored == 0 {
+			frame.Selection = selectedFallback
+		}
+		if err := extractFrame(ctx, inPath, framePath, best.At, 0); err != nil {
+			if ctx.Err() != nil || best.At == 0 {
+				return chosenFrame{}, nil, err
+			}
+			frame = chosenFrame{Selection: selectedFallback}
+			if err := extractFrame(ctx, inPath, framePath, 0, 0); err != nil {
+				return chosenFrame{}, nil, err
+			}
+		}
+	}
+	thumbs, err := renderVariants(ctx, bucket, videoID, framePath, sizes, formats)
+	if err != nil {
+		return chosenFrame{}, nil, err
+	}
+	return frame, thumbs, nil
+}
+
+func downloadObject(ctx context.Context, bucket, key string) (string, error) {
+	inFile, err := os.CreateTemp("", "video-in-*")
+	if err != nil {
+		return "", err
+	}
+	defer inFile.Close()
+
+	obj, _, err := objectStore.Get(ctx, bucket, key)
+	if err != nil {
+		os.Remove(inFile.Name())
+		return "", err
+	}
+	defer obj.Close()
+
+	if _, err := io.Copy(inFile, obj); err != nil {
+		os.Remove(inFile.Name())
+		return "", err
+	}
+	return inFile.Name(), nil
+}
+
+// extractFrame writes the frame at the given time, scaled to width unless it
+// is 0. Seeking past the end makes ffmpeg succeed without output, which is
+// reported as an error.
+func extractFrame(ctx context.Context, inPath, outPath string, at float64, width int) error {
+	args := []string{
+		"-y",
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", inPath,
+		"-frames:v", "1",
+		"-q:v", "2",
+	}
+	if width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", width))
+	}
+	os.Remove(outPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", append(args, outPath)...)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	if st, err := os.Stat(outPath); err != nil || st.Size() == 0 {
+		return fmt.Errorf("ffmpeg: no frame at %.3fs", at)
+	}
+	return nil
+}
+
+// uploadFile stores the file at path under key and returns its size.
+func uploadFile(ctx context.Context, bucket, key, path, contentType string) (int64, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+
+	stat, err := fh.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = objectStore.Put(ctx, bucket, key, fh, stat.Size(), contentType)
+	return stat.Size(), err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type ThumbnailSize struct {
+	Name  string
+	Width int
+}
+
+// defaultSize is the full-size frame every other size is scaled from.
+const defaultSize = "default"
+
+// ThumbnailFormat is an image encoding thumbnails are written in.
+type ThumbnailFormat struct {
+	Name        string
+	Ext         string
+	ContentType string
+	// Args are the ffmpeg encoder options.
+	Args []string
+}
+
+// thumbnailFormats are the formats THUMBNAIL_FORMATS may list. JPEG is always
+// rendered: it is what the node falls back to for clients that accept
+// nothing newer.
+var thumbnailFormats = map[string]ThumbnailFormat{
+	"jpeg": {Name: "jpeg", Ext: "jpg", ContentType: "image/jpeg", Args: []string{"-q:v", "3"}},
+	"webp": {Name: "webp", Ext: "webp", ContentType: "image/webp", Args: []string{"-c:v", "libwebp", "-quality", "80"}},
+	"avif": {Name: "avif", Ext: "avif", ContentType: "image/avif", Args: []string{
+		"-c:v", "libaom-av1", "-still-picture", "1", "-crf", "32", "-b:v", "0", "-cpu-used", "6", "-pix_fmt", "yuv420p",
+	}},
+}
+
+var defaultThumbnailSizes = []ThumbnailSize{
+	{Name: "small", Width: 160},
+	{Name: "medium", Width: 320},
+	{Name: "large", Width: 640},
+}
+
+// Thumbnail is one rendered variant, as reported to the node.
+type Thumbnail struct {
+	Size   string `json:"size"`
+	Format string `json:"format"`
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// ThumbnailKey is where the size variant of a video's thumbnail is stored in
+// format, e.g. derived/{id}/thumbnails/small.webp.
+func ThumbnailKey(videoID, size string, format ThumbnailFormat) string {
+	return ThumbnailPrefix(videoID) + size + "." + format.Ext
+}
+
+// parseThumbnailSizes reads "name:width,..." as in THUMBNAIL_SIZES.
+func parseThumbnailSizes(v string) ([]ThumbnailSize, error) {
+	var sizes []ThumbnailSize
+	seen := map[string]bool{defaultSize: true}
+	for _, item := range strings.Split(v, ",") {
+		name, width, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok {
+			return nil, fmt.Errorf("size %q is not name:width", item)
+		}
+		w, err := strconv.Atoi(width)
+		if err != nil || w < 16 || w > 3840 {
+			return nil, fmt.Errorf("size %q needs a width between 16 and 3840", item)
+		}
+		if !validSizeName(name) || seen[name] {
+			return nil, fmt.Errorf("size name %q is invalid or repeated", name)
+		}
+		seen[name] = true
+		sizes = append(sizes, ThumbnailSize{Name: name, Width: w})
+	}
+	return sizes, nil
+}
+
+// parseThumbnailFormats reads a comma-separated list as in THUMBNAIL_FORMATS,
+// putting JPEG first whether or not it is listed.
+func parseThumbnailFormats(v string) ([]ThumbnailFormat, error) {
+	formats := []ThumbnailFormat{thumbnailFormats["jpeg"]}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "jpg" {
+			name = "jpeg"
+		}
+		f, ok := thumbnailFormats[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown thumbnail format %q", name)
+		}
+		if f.Name != "jpeg" {
+			formats = append(formats, f)
+		}
+	}
+	return formats, nil
+}
+
+// validSizeName reports whether name can be used in an object key and a
+// query parameter as is.
+func validSizeName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// renderVariants encodes framePath at every size in every format and uploads
+// the results. Sizes never upscale past the frame's own width.
+func renderVariants(ctx context.Context, bucket, videoID, framePath string, sizes []ThumbnailSize, formats []ThumbnailFormat) ([]Thumbnail, error) {
+	workDir := filepath.Dir(framePath)
+	all := append([]ThumbnailSize{{Name: defaultSize}}, sizes...)
+
+	var thumbs []Thumbnail
+	for _, size := range all {
+		var width, height int
+		for _, format := range formats {
+			outPath := filepath.Join(workDir, size.Name+"."+format.Ext)
+			if size.Name == defaultSize && format.Name == "jpeg" {
+				outPath = framePath
+			} else if err := encodeImage(ctx, framePath, outPath, size.Width, format); err != nil {
+				return nil, fmt.Errorf("%s %s thumbnail: %w", size.Name, format.Name, err)
+			}
+
+			// JPEG comes first, and the other formats share its dimensions.
+			if format.Name == "jpeg" {
+				var err error
+				if width, height, err = jpegSize(outPath); err != nil {
+					log.Printf("thumbnail %s: %v", outPath, err)
+				}
+			}
+
+			key := ThumbnailKey(videoID, size.Name, format)
+			n, err := uploadFile(ctx, bucket, key, outPath, format.ContentType)
+			if err != nil {
+				return nil, err
+			}
+			thumbs = append(thumbs, Thumbnail{
+				Size:   size.Name,
+				Format: format.Name,
+				Key:    key,
+				Width:  width,
+				Height: height,
+				Bytes:  n,
+			})
+		}
+	}
+	return thumbs, nil
+}
+
+// encodeImage writes inPath to outPath in format, scaled to at most width
+// (keeping the aspect ratio and an even height) unless width is 0.
+func encodeImage(ctx context.Context, inPath, outPath string, width int, format ThumbnailFormat) error {
+	args := []string{"-y", "-i", inPath}
+	if width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale='min(%d,iw)':-2", width))
+	}
+	args = append(args, format.Args...)
+	args = append(args, "-frames:v", "1", outPath)
+	if out, err := exec.CommandContext(ctx, "ffmpeg", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, lastLine(out))
+	}
+	return nil
+}
+
+func jpegSize(path string) (int, int, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fh.Close()
+	cfg, _, err := image.DecodeConfig(fh)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// lastLine returns the final line of ffmpeg's output, which usually names the
+// problem (e.g. a missing encoder).
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return lines[len(lines)-1]
+}
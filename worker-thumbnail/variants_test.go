@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseThumbnailSizes(t *testing.T) {
+	sizes, err := parseThumbnailSizes("small:160, wide_2:1280")
+	want := []ThumbnailSize{{Name: "small", Width: 160}, {Name: "wide_2", Width: 1280}}
+	if err != nil || !reflect.DeepEqual(sizes, want) {
+		t.Errorf("parseThumbnailSizes = %+v, %v; want %+v", sizes, err, want)
+	}
+
+	for _, v := range []string{
+		"",
+		"small",
+		"small:0",
+		"small:-160",
+		"small:8",
+		"small:4000",
+		"small:wide",
+		"Small:160",
+		"sm/all:160",
+		"default:320",
+		"small:160,small:320",
+	} {
+		if sizes, err := parseThumbnailSizes(v); err == nil {
+			t.Errorf("parseThumbnailSizes(%q) = %+v, want an error", v, sizes)
+		}
+	}
+}
+
+func TestParseThumbnailFormats(t *testing.T) {
+	for v, want := range map[string][]string{
+		"jpeg":           {"jpeg"},
+		"webp":           {"jpeg", "webp"},
+		"avif, WEBP":     {"jpeg", "avif", "webp"},
+		"webp,jpg,avif":  {"jpeg", "webp", "avif"},
+		"jpeg,webp,avif": {"jpeg", "webp", "avif"},
+	} {
+		formats, err := parseThumbnailFormats(v)
+		if err != nil {
+			t.Errorf("parseThumbnailFormats(%q): %v", v, err)
+			continue
+		}
+		var names []string
+		for _, f := range formats {
+			names = append(names, f.Name)
+		}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("parseThumbnailFormats(%q) = %v, want %v", v, names, want)
+		}
+	}
+
+	for _, v := range []string{"", "png", "webp,gif", "webp,"} {
+		if formats, err := parseThumbnailFormats(v); err == nil {
+			t.Errorf("parseThumbnailFormats(%q) = %+v, want an error", v, formats)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+
+	"platform/worker"
+)
+
+// ReportRenditions tells the leader which renditions and streaming formats
+// are available so it can update the video record.
+func ReportRenditions(ctx context.Context, nodeURL, videoID string, renditions, formats []string) error {
+	return worker.PostCallback(ctx, nodeURL, videoID, "renditions", map[string][]string{
+		"renditions": renditions,
+		"formats":    formats,
+	})
+}
+
+// ReportProbe sends the extracted media information to the leader.
+func ReportProbe(ctx context.Context, nodeURL, videoID string, info ProbeInfo) error {
+	return worker.PostCallback(ctx, nodeURL, videoID, "probe", info)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"platform/worker"
+)
+
+// DASHPrefix must match the node's DASHPrefix.
+func DASHPrefix(videoID string) string {
+	return "derived/" + videoID + "/dash/"
+}
+
+// PackageCMAF transcodes srcKey once into fragmented-MP4 (CMAF) segments and
+// emits both an HLS master playlist and a DASH MPD over the same segments.
+// Segments and HLS playlists go under HLSPrefix(videoID); the MPD goes under
+// DASHPrefix(videoID) with a BaseURL pointing back at the shared segments.
+func PackageCMAF(ctx context.Context, bucket, srcKey, videoID string) ([]string, error) {
+	inPath, err := downloadObject(ctx, bucket, srcKey)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(inPath)
+
+	src, err := probeSource(ctx, inPath)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := os.MkdirTemp("", "cmaf-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	rungs, err := selectLadder(src)
+	if err != nil {
+		return nil, err
+	}
+	// The encode is most of the work; leave the rest for the upload.
+	progress := func(done float64) { worker.ReportProgress(ctx, 0.9*done) }
+	if err := transcodeCMAF(ctx, inPath, workDir, rungs, src, progress); err != nil {
+		return nil, err
+	}
+	worker.ReportProgress(ctx, 0.9)
+
+	mpdPath := filepath.Join(workDir, "manifest.mpd")
+	if err := rebaseMPD(mpdPath, "../hls/"); err != nil {
+		return nil, err
+	}
+
+	hlsPrefix := HLSPrefix(videoID)
+	if err := uploadDir(ctx, bucket, workDir, hlsPrefix, "master.m3u8", "manifest.mpd"); err != nil {
+		return nil, err
+	}
+	if err := uploadFile(ctx, bucket, hlsPrefix+"master.m3u8", filepath.Join(workDir, "master.m3u8"), mediaContentTypes[".m3u8"]); err != nil {
+		return nil, err
+	}
+	if err := uploadFile(ctx, bucket, DASHPrefix(videoID)+"manifest.mpd", mpdPath, mediaContentTypes[".mpd"]); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(rungs))
+	for _, r := range rungs {
+		names = append(names, r.Name)
+	}
+	return names, nil
+}
+
+// transcodeCMAF encodes every rung in a single ffmpeg run with the dash muxer,
+// which also writes HLS playlists (-hls_playlist) for the same segments.
+// progress is called with the fraction of the source encoded so far.
+func transcodeCMAF(ctx context.Context, inPath, outDir string, rungs []Rendition, src sourceInfo, progress func(float64)) error {
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "[0:v]split=%d", len(rungs))
+	for i := range rungs {
+		fmt.Fprintf(&filter, "[s%d]", i)
+	}
+	for i, r := range rungs {
+		fmt.Fprintf(&filter, ";[s%d]scale=-2:%d[v%d]", i, r.Height, i)
+	}
+
+	args := []string{
+		"-y",
+		"-progress", "pipe:1",
+		"-nostats",
+		"-i", inPath,
+		"-filter_complex", filter.String(),
+	}
+	for i, r := range rungs {
+		args = append(args,
+			"-map", fmt.Sprintf("[v%d]", i),
+			fmt.Sprintf("-c:v:%d", i), "libx264",
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate),
+			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+			fmt.Sprintf("-bufsize:v:%d", i), fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+		)
+	}
+	args = append(args,
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-force_key_frames", "expr:gte(t,n_forced*2)",
+		"-sc_threshold", "0",
+	)
+
+	adaptationSets := "id=0,streams=v"
+	if src.HasAudio {
+		// One audio representation per bitrate in the ladder, so players
+		// on the low rungs are not stuck with the top rung's audio.
+		for i, kbps := range audioBitrates(rungs) {
+			args = append(args,
+				"-map", "0:a:0",
+				fmt.Sprintf("-b:a:%d", i), fmt.Sprintf("%dk", kbps),
+			)
+		}
+		args = append(args, "-c:a", "aac", "-ac", "2")
+		adaptationSets += " id=1,streams=a"
+	}
+
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", fmt.Sprint(hlsSegmentSeconds),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-adaptation_sets", adaptationSets,
+		"-hls_playlist", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		filepath.Join(outDir, "manifest.mpd"),
+	)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("ffmpeg: %w", err)
+	}
+	trackProgress(stdout, src.Duration, progress)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+// audioBitrates lists the distinct audio bitrates of rungs, in ladder order.
+func audioBitrates(rungs []Rendition) []int {
+	var out []int
+	seen := map[int]bool{}
+	for _, r := range rungs {
+		if !seen[r.AudioBitrate] {
+			seen[r.AudioBitrate] = true
+			out = append(out, r.AudioBitrate)
+		}
+	}
+	return out
+}
+
+// trackProgress reads ffmpeg -progress output until EOF and calls progress
+// with how far into a source of duration seconds the encode has got. Without
+// a known duration it only drains r.
+func trackProgress(r io.Reader, duration float64, progress func(float64)) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		// Despite its name, out_time_ms is in microseconds too.
+		if duration <= 0 || (key != "out_time_us" && key != "out_time_ms") {
+			continue
+		}
+		us, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || us < 0 {
+			continue
+		}
+		progress(math.Min(float64(us)/1e6/duration, 1))
+	}
+	// Keep draining so ffmpeg never blocks on a full pipe.
+	_, _ = io.Copy(io.Discard, r)
+}
+
+// rebaseMPD inserts a BaseURL ahead of the first Period so segment URLs in
+// the MPD resolve against baseURL instead of the manifest's own location.
+func rebaseMPD(path, baseURL string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	mpd := string(data)
+	i := strings.Index(mpd, "<Period")
+	if i < 0 {
+		return fmt.Errorf("manifest.mpd: no Period element")
+	}
+	mpd = mpd[:i] + "<BaseURL>" + baseURL + "</BaseURL>\n\t" + mpd[i:]
+	return os.WriteFile(path, []byte(mpd), 0o644)
+}
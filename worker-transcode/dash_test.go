@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRebaseMPD(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.mpd")
+	mpd := "<?xml version=\"1.0\"?>\n<MPD>\n\t<Period id=\"0\">\n\t</Period>\n</MPD>\n"
+	if err := os.WriteFile(path, []byte(mpd), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rebaseMPD(path, "../hls/"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(data)
+	base := strings.Index(got, "<BaseURL>../hls/</BaseURL>")
+	if base < 0 || base > strings.Index(got, "<Period") {
+		t.Errorf("rebased MPD = %q, want the BaseURL before the first Period", got)
+	}
+
+	if err := os.WriteFile(path, []byte("<MPD></MPD>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rebaseMPD(path, "../hls/"); err == nil {
+		t.Error("rebaseMPD accepted an MPD without a Period")
+	}
+}
+
+func TestTrackProgress(t *testing.T) {
+	out := "frame=10\nout_time_us=2500000\nout_time_ms=2500000\nprogress=continue\n" +
+		"out_time_us=N/A\nout_time_us=5000000\nout_time_us=12000000\nprogress=end\n"
+
+	var got []float64
+	trackProgress(strings.NewReader(out), 10, func(f float64) { got = append(got, f) })
+	want := []float64{0.25, 0.25, 0.5, 1}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("progress = %v, want %v", got, want)
+	}
+
+	got = nil
+	trackProgress(strings.NewReader(out), 0, func(f float64) { got = append(got, f) })
+	if len(got) != 0 {
+		t.Errorf("progress without a duration = %v, want none", got)
+	}
+}
+
+func TestAudioBitrates(t *testing.T) {
+	if got := audioBitrates(ladder); fmt.Sprint(got) != "[64 96 128 192]" {
+		t.Errorf("audio bitrates = %v, want [64 96 128 192]", got)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"platform/worker"
+)
+
+func main() {
+	cfg := LoadConfig()
+
+	if err := InitStorage(cfg); err != nil {
+		log.Fatalf("init %s storage: %v", cfg.StorageBackend, err)
+	}
+	if err := InitBroker(cfg); err != nil {
+		log.Fatalf("init %s broker: %v", cfg.BrokerBackend, err)
+	}
+	log.Println("worker-transcode: connected to broker & object storage")
+	worker.Init(worker.Config{
+		Broker:      broker,
+		NodeAPIURL:  cfg.NodeAPIURL,
+		WorkerToken: cfg.WorkerToken,
+		Bucket:      cfg.MinIOBucket,
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	worker.StartAdminServer(cfg.AdminPort)
+
+	// Probing gets its own queue so metadata lands within seconds instead of
+	// waiting behind long transcodes.
+	err := worker.RegisterJob(worker.JobSpec{
+		Type:        "probe",
+		Queue:       "jobs.probe",
+		Concurrency: 1,
+		Prefetch:    1,
+		Timeout:     2 * time.Minute,
+		Retry:       cfg.Retry,
+		Handle:      probeJob(cfg),
+	})
+	if err != nil {
+		log.Fatalf("register jobs: %v", err)
+	}
+	// Transcodes are long; never hold more than one unacked job.
+	err = worker.RegisterJob(worker.JobSpec{
+		Type:        "transcode",
+		Queue:       "jobs.transcode",
+		Concurrency: 1,
+		Prefetch:    1,
+		Timeout:     cfg.TranscodeTimeout,
+		Retry:       cfg.Retry,
+		Handle:      transcodeJob(cfg),
+	})
+	if err != nil {
+		log.Fatalf("register jobs: %v", err)
+	}
+	if err := worker.StartJobs(ctx); err != nil {
+		log.Fatalf("consume: %v", err)
+	}
+
+	// A signal cancels ctx, which kills running ffmpeg processes; those jobs
+	// are requeued to restart on another worker rather than waited for.
+	<-ctx.Done()
+	log.Println("worker-transcode: shutting down")
+	broker.Drain(10 * time.Second)
+	_ = broker.Close()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"platform/worker"
+)
+
+// ProbeInfo mirrors the node's ProbeInfo.
+type ProbeInfo struct {
+	DurationSeconds float64 `json:"duration_seconds"`
+	BitRate         int64   `json:"bit_rate"`
+	Container       string  `json:"container"`
+	VideoCodec      string  `json:"video_codec,omitempty"`
+	AudioCodec      string  `json:"audio_codec,omitempty"`
+	Width           int     `json:"width,omitempty"`
+	Height          int     `json:"height,omitempty"`
+	FrameRate       float64 `json:"frame_rate,omitempty"`
+	Rotation        int     `json:"rotation,omitempty"`
+}
+
+type ffprobeStream struct {
+	CodecType    string            `json:"codec_type"`
+	CodecName    string            `json:"codec_name"`
+	Width        int               `json:"width"`
+	Height       int               `json:"height"`
+	AvgFrameRate string            `json:"avg_frame_rate"`
+	RFrameRate   string            `json:"r_frame_rate"`
+	Tags         map[string]string `json:"tags"`
+	SideData     []struct {
+		Rotation int `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+func runFFprobe(ctx context.Context, input string) (ffprobeOutput, error) {
+	var probe ffprobeOutput
+	out, err := execOutput(ctx, "ffprobe",
+		"-v", "error",
+		"-show_format",
+		"-show_streams",
+		"-of", "json",
+		input,
+	)
+	if err != nil {
+		return probe, fmt.Errorf("ffprobe: %w", err)
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return probe, fmt.Errorf("decode ffprobe output: %w", err)
+	}
+	return probe, nil
+}
+
+func (s ffprobeStream) rotation() int {
+	if len(s.SideData) > 0 && s.SideData[0].Rotation != 0 {
+		return s.SideData[0].Rotation
+	}
+	if v, ok := s.Tags["rotate"]; ok {
+		r, _ := strconv.Atoi(v)
+		return r
+	}
+	return 0
+}
+
+// probeJob records the container, codecs and dimensions of an upload on the
+// video.
+func probeJob(cfg Config) worker.JobFunc {
+	return func(ctx context.Context, job worker.Job) (worker.JobResult, error) {
+		info, err := ProbeMedia(ctx, job.Bucket, job.Object)
+		if err != nil {
+			return worker.JobResult{}, err
+		}
+		if err := ReportProbe(ctx, cfg.NodeAPIURL, job.VideoID, info); err != nil {
+			return worker.JobResult{}, err
+		}
+		return worker.JobResult{Details: map[string]interface{}{
+			"container":   info.Container,
+			"video_codec": info.VideoCodec,
+			"width":       info.Width,
+			"height":      info.Height,
+			"duration":    info.DurationSeconds,
+		}}, nil
+	}
+}
+
+// ProbeMedia extracts ProbeInfo from the object without downloading it:
+// ffprobe reads the presigned URL with range requests.
+func ProbeMedia(ctx context.Context, bucket, key string) (ProbeInfo, error) {
+	url, err := objectStore.Presign(ctx, bucket, key, 15*time.Minute)
+	if err != nil {
+		return ProbeInfo{}, err
+	}
+	probe, err := runFFprobe(ctx, url)
+	if err != nil {
+		return ProbeInfo{}, err
+	}
+
+	var info ProbeInfo
+	info.DurationSeconds, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	info.BitRate, _ = strconv.ParseInt(probe.Format.BitRate, 10, 64)
+	// format_name lists every demuxer alias ("mov,mp4,m4a,..."); the first
+	// is the canonical one.
+	info.Container, _, _ = strings.Cut(probe.Format.FormatName, ",")
+
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			if info.VideoCodec != "" {
+				continue
+			}
+			info.VideoCodec = s.CodecName
+			info.Width, info.Height = s.Width, s.Height
+			info.FrameRate = parseFrameRate(s.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = parseFrameRate(s.RFrameRate)
+			}
+			info.Rotation = s.rotation()
+		case "audio":
+			if info.AudioCodec == "" {
+				info.AudioCodec = s.CodecName
+			}
+		}
+	}
+	if info.VideoCodec == "" && info.AudioCodec == "" {
+		return ProbeInfo{}, fmt.Errorf("no audio or video streams in %s", key)
+	}
+	return info, nil
+}
+
+// parseFrameRate turns ffprobe's rational ("30000/1001") into fps.
+func parseFrameRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(s, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+func probeSource(ctx context.Context, path string) (sourceInfo, error) {
+	probe, err := runFFprobe(ctx, path)
+	if err != nil {
+		return sourceInfo{}, err
+	}
+
+	var src sourceInfo
+	src.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	for _, s := range probe.Streams {
+		switch s.CodecType {
+		case "video":
+			if src.Height != 0 {
+				continue
+			}
+			src.Width, src.Height = s.Width, s.Height
+			src.FrameRate = parseFrameRate(s.AvgFrameRate)
+			if src.FrameRate == 0 {
+				src.FrameRate = parseFrameRate(s.RFrameRate)
+			}
+			// ffmpeg auto-rotates, so portrait sources come out with
+			// width and height swapped.
+			switch s.rotation() {
+			case 90, -90, 270, -270:
+				src.Width, src.Height = src.Height, src.Width
+			}
+		case "audio":
+			src.HasAudio = true
+		}
+	}
+	if src.Height == 0 {
+		return sourceInfo{}, worker.Permanent(fmt.Errorf("no video stream found"))
+	}
+	return src, nil
+}
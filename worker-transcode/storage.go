@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+
+	"platform/storage"
+)
+
+var objectStore storage.ObjectStore
+
+func InitStorage(cfg Config) error {
+	store, err := storage.Open(storage.Config{
+		Backend:        cfg.StorageBackend,
+		MinIOEndpoint:  cfg.MinIOEndpoint,
+		MinIOAccessKey: cfg.MinIOAccessKey,
+		MinIOSecretKey: cfg.MinIOSecretKey,
+		LocalPath:      cfg.LocalStoragePath,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := store.EnsureBucket(context.Background(), cfg.MinIOBucket); err != nil {
+		return err
+	}
+	objectStore = store
+	return nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"platform/worker"
+)
+
+type Rendition struct {
+	Name         string
+	Height       int
+	VideoBitrate int // kbit/s
+	AudioBitrate int // kbit/s
+}
+
+// ladder is the full ABR ladder; selectLadder trims it to the source.
+var ladder = []Rendition{
+	{Name: "240p", Height: 240, VideoBitrate: 400, AudioBitrate: 64},
+	{Name: "360p", Height: 360, VideoBitrate: 800, AudioBitrate: 96},
+	{Name: "480p", Height: 480, VideoBitrate: 1400, AudioBitrate: 128},
+	{Name: "720p", Height: 720, VideoBitrate: 2800, AudioBitrate: 128},
+	{Name: "1080p", Height: 1080, VideoBitrate: 5000, AudioBitrate: 192},
+}
+
+const hlsSegmentSeconds = 6
+
+var mediaContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mpd":  "application/dash+xml",
+}
+
+// HLSPrefix must match the node's HLSPrefix.
+func HLSPrefix(videoID string) string {
+	return "derived/" + videoID + "/hls/"
+}
+
+type sourceInfo struct {
+	Width     int
+	Height    int
+	FrameRate float64
+	HasAudio  bool
+	Duration  float64 // seconds; 0 if unknown
+}
+
+// selectLadder keeps the rungs that do not upscale the source. Sources
+// smaller than the lowest rung get a single rendition at their own height;
+// a source without a usable video stream cannot be transcoded at all.
+func selectLadder(src sourceInfo) ([]Rendition, error) {
+	if src.Height < 2 || src.Width < 2 {
+		return nil, worker.Permanent(fmt.Errorf("cannot transcode a %dx%d video", src.Width, src.Height))
+	}
+	var out []Rendition
+	for _, r := range ladder {
+		if r.Height <= src.Height {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		r := ladder[0]
+		r.Height = src.Height &^ 1
+		r.Name = fmt.Sprintf("%dp", r.Height)
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// rungWidth is the even width that keeps the source's aspect ratio at r.
+func rungWidth(r Rendition, src sourceInfo) int {
+	return (src.Width*r.Height/src.Height + 1) &^ 1
+}
+
+// h264Levels are the H.264 levels with their limits on macroblocks per second
+// and per frame and on bitrate (kbit/s, Main profile).
+var h264Levels = []struct {
+	idc                int
+	mbps, frame, kbits int
+}{
+	{10, 1485, 99, 64},
+	{11, 3000, 396, 192},
+	{12, 6000, 396, 384},
+	{13, 11880, 396, 768},
+	{20, 11880, 396, 2000},
+	{21, 19800, 792, 4000},
+	{22, 20250, 1620, 4000},
+	{30, 40500, 1620, 10000},
+	{31, 108000, 3600, 14000},
+	{32, 216000, 5120, 20000},
+	{40, 245760, 8192, 20000},
+	{41, 245760, 8192, 50000},
+	{42, 522240, 8704, 50000},
+	{50, 589824, 22080, 135000},
+	{51, 983040, 36864, 240000},
+	{52, 2073600, 36864, 240000},
+}
+
+// h264Codec is the RFC 6381 codec string for rung r encoded with the Main
+// profile, at the lowest level that fits its frame size, rate and bitrate.
+func h264Codec(r Rendition, src sourceInfo) string {
+	fps := src.FrameRate
+	if fps <= 0 {
+		fps = 30
+	}
+	mbs := ((rungWidth(r, src) + 15) / 16) * ((r.Height + 15) / 16)
+	maxrate := r.VideoBitrate * 107 / 100
+	level := h264Levels[len(h264Levels)-1].idc
+	for _, l := range h264Levels {
+		if mbs <= l.frame && float64(mbs)*fps <= float64(l.mbps) && maxrate <= l.kbits {
+			level = l.idc
+			break
+		}
+	}
+	return fmt.Sprintf("avc1.4d40%02x", level)
+}
+
+// transcodeJob packages a video for adaptive streaming, as CMAF for HLS and
+// DASH when enabled and as HLS/TS otherwise.
+func transcodeJob(cfg Config) worker.JobFunc {
+	return func(ctx context.Context, job worker.Job) (worker.JobResult, error) {
+		var (
+			renditions []string
+			formats    []string
+			outputs    []string
+			err        error
+		)
+		if cfg.EnableDASH {
+			log.Printf("packaging CMAF (HLS+DASH) for video %s (s3://%s/%s)", job.VideoID, job.Bucket, job.Object)
+			renditions, err = PackageCMAF(ctx, job.Bucket, job.Object, job.VideoID)
+			formats = []string{"hls", "dash"}
+			outputs = []string{HLSPrefix(job.VideoID), DASHPrefix(job.VideoID)}
+		} else {
+			prefix := HLSPrefix(job.VideoID)
+			log.Printf("packaging HLS for video %s (s3://%s/%s) -> %s", job.VideoID, job.Bucket, job.Object, prefix)
+			renditions, err = PackageHLS(ctx, job.Bucket, job.Object, prefix)
+			formats = []string{"hls"}
+			outputs = []string{prefix}
+		}
+		if err != nil {
+			return worker.JobResult{}, err
+		}
+
+		if err := ReportRenditions(ctx, cfg.NodeAPIURL, job.VideoID, renditions, formats); err != nil {
+			return worker.JobResult{}, err
+		}
+		return worker.JobResult{
+			Outputs: outputs,
+			Details: map[string]interface{}{"renditions": renditions, "formats": formats},
+		}, nil
+	}
+}
+
+// PackageHLS transcodes srcKey into the ABR ladder, uploads segments, variant
+// playlists and master.m3u8 under prefix, and returns the rendition names.
+func PackageHLS(ctx context.Context, bucket, srcKey, prefix string) ([]string, error) {
+	inPath, err := downloadObject(ctx, bucket, srcKey)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(inPath)
+
+	src, err := probeSource(ctx, inPath)
+	if err != nil {
+		return nil, err
+	}
+
+	workDir, err := os.MkdirTemp("", "hls-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	rungs, err := selectLadder(src)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(rungs))
+	for _, r := range rungs {
+		outDir := filepath.Join(workDir, r.Name)
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			return nil, err
+		}
+		if err := transcodeRendition(ctx, inPath, outDir, r, src); err != nil {
+			return nil, fmt.Errorf("%s: %w", r.Name, err)
+		}
+		names = append(names, r.Name)
+		// Leave the last step for the upload.
+		worker.ReportProgress(ctx, float64(len(names))/float64(len(rungs)+1))
+	}
+
+	if err := writeMasterPlaylist(filepath.Join(workDir, "master.m3u8"), rungs, src); err != nil {
+		return nil, err
+	}
+
+	// Upload the master playlist last so players never see it before the
+	// variants it references.
+	if err := uploadDir(ctx, bucket, workDir, prefix, "master.m3u8"); err != nil {
+		return nil, err
+	}
+	if err := uploadFile(ctx, bucket, prefix+"master.m3u8", filepath.Join(workDir, "master.m3u8"), mediaContentTypes[".m3u8"]); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func transcodeRendition(ctx context.Context, inPath, outDir string, r Rendition, src sourceInfo) error {
+	args := []string{
+		"-y",
+		"-i", inPath,
+		"-map", "0:v:0",
+	}
+	if src.HasAudio {
+		args = append(args, "-map", "0:a:0")
+	}
+	args = append(args,
+		"-vf", fmt.Sprintf("scale=-2:%d", r.Height),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-profile:v", "main",
+		"-b:v", fmt.Sprintf("%dk", r.VideoBitrate),
+		"-maxrate", fmt.Sprintf("%dk", r.VideoBitrate*107/100),
+		"-bufsize", fmt.Sprintf("%dk", r.VideoBitrate*3/2),
+		// Keyframes every 2s regardless of frame rate keep segment
+		// boundaries aligned across renditions.
+		"-force_key_frames", "expr:gte(t,n_forced*2)",
+		"-sc_threshold", "0",
+	)
+	if src.HasAudio {
+		args = append(args,
+			"-c:a", "aac",
+			"-b:a", fmt.Sprintf("%dk", r.AudioBitrate),
+			"-ac", "2",
+		)
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", fmt.Sprint(hlsSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(outDir, "seg_%04d.ts"),
+		filepath.Join(outDir, "index.m3u8"),
+	)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+func writeMasterPlaylist(path string, rungs []Rendition, src sourceInfo) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+
+	for _, r := range rungs {
+		bandwidth := r.VideoBitrate * 1000
+		codecs := h264Codec(r, src)
+		if src.HasAudio {
+			bandwidth += r.AudioBitrate * 1000
+			codecs += ",mp4a.40.2"
+		}
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"\n%s/index.m3u8\n",
+			bandwidth, rungWidth(r, src), r.Height, codecs, r.Name)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+func downloadObject(ctx context.Context, bucket, key string) (string, error) {
+	inFile, err := os.CreateTemp("", "video-in-*")
+	if err != nil {
+		return "", err
+	}
+	defer inFile.Close()
+
+	obj, _, err := objectStore.Get(ctx, bucket, key)
+	if err != nil {
+		os.Remove(inFile.Name())
+		return "", err
+	}
+	defer obj.Close()
+
+	if _, err := io.Copy(inFile, obj); err != nil {
+		os.Remove(inFile.Name())
+		return "", err
+	}
+	return inFile.Name(), nil
+}
+
+// uploadDir uploads every file below dir to prefix, preserving relative
+// paths and skipping the names in skip.
+func uploadDir(ctx context.Context, bucket, dir, prefix string, skip ...string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		for _, s := range skip {
+			if rel == s {
+				return nil
+			}
+		}
+		contentType := mediaContentTypes[filepath.Ext(p)]
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		return uploadFile(ctx, bucket, prefix+rel, p, contentType)
+	})
+}
+
+func uploadFile(ctx context.Context, bucket, key, path, contentType string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	stat, err := fh.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = objectStore.Put(ctx, bucket, key, fh, stat.Size(), contentType)
+	return err
+}
+
+func execOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, lastLine(stderr.String()))
+	}
+	return out, nil
+}
+
+func lastLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
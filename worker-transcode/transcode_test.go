@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"platform/worker"
+)
+
+func TestSelectLadderRejectsSourcesWithoutVideo(t *testing.T) {
+	for _, src := range []sourceInfo{{}, {Width: 640}, {Width: 1, Height: 1}} {
+		_, err := selectLadder(src)
+		if !worker.IsPermanent(err) {
+			t.Errorf("selectLadder(%+v) = %v, want a permanent error", src, err)
+		}
+	}
+	rungs, err := selectLadder(sourceInfo{Width: 200, Height: 113})
+	if err != nil || len(rungs) != 1 || rungs[0].Height != 112 {
+		t.Errorf("selectLadder(200x113) = %+v, %v; want one 112p rung", rungs, err)
+	}
+}
+
+func TestH264CodecLevelFollowsResolution(t *testing.T) {
+	src := sourceInfo{Width: 1920, Height: 1080, FrameRate: 30}
+	want := map[string]string{
+		"240p":  "avc1.4d4015",
+		"360p":  "avc1.4d401e",
+		"480p":  "avc1.4d401f",
+		"720p":  "avc1.4d401f",
+		"1080p": "avc1.4d4028",
+	}
+	rungs, err := selectLadder(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rungs {
+		if got := h264Codec(r, src); got != want[r.Name] {
+			t.Errorf("%s codec = %s, want %s", r.Name, got, want[r.Name])
+		}
+	}
+
+	src.FrameRate = 60
+	if got := h264Codec(ladder[4], src); got != "avc1.4d402a" {
+		t.Errorf("1080p60 codec = %s, want avc1.4d402a", got)
+	}
+}